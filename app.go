@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -12,35 +13,60 @@ import (
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type App struct {
+	Ctx        context.Context
+	stop       context.CancelCauseFunc
 	Bot        *tgbotapi.BotAPI
 	BotUpdates tgbotapi.UpdatesChannel
 	TorClient  *torrent.Client
+	Stream     TorrentStream
+	Seeder     *Seeder
+	Disk       *DiskBudget
 	Queue      chan QueueMessages
 
-	ChatsWork        ChatsWork
-	TorrentChatsWork ChatsWork
-	LockForRemove    sync.WaitGroup
+	ChatsWork ChatsWork
+	Scheduler *Scheduler
+	Tasks     sync.Map
+	// Workspaces - folders of the running tasks, the janitor doesn't remove them
+	Workspaces sync.Map
+
+	// running - goroutines of the tasks, draining - new tasks are not started, the bot is shutting down
+	running  sync.WaitGroup
+	draining atomic.Bool
 }
 
 type QueueMessages struct {
 	Message *tgbotapi.Message
+	Job     *Job
+	// Torrent - files are chosen in the picker or the job is resumed, Message.Text keeps the chosen files
+	Torrent *torrent.Torrent
 }
 
-func Run() App {
-	app := App{}
+func Run() *App {
+	// apply schema migrations, the updates aren't taken till the schema is ready
+	MigrateOnStart()
+
+	app := &App{}
+	app.Ctx, app.stop = context.WithCancelCause(context.Background())
 
 	// create queue
 	app.Queue = make(chan QueueMessages, 0)
 
-	// create lock turn
-	app.ChatsWork = ChatsWork{m: sync.Map{}}
-	app.TorrentChatsWork = ChatsWork{m: sync.Map{}}
+	// create scheduler, workers per class
+	app.Scheduler = NewScheduler(map[string]int{
+		"video-url":  config.MaxTasks,
+		"spotify":    config.MaxTasksSpotify,
+		"torrent":    config.MaxTasksTorrent,
+		"convert":    config.MaxTasksConvert,
+		"direct-url": config.MaxTasksDirect,
+	})
 
 	var err error
 	// init bot
@@ -59,9 +85,6 @@ func Run() App {
 
 	app.BotUpdates = app.Bot.GetUpdatesChan(u)
 
-	// create table is not exist
-	app.initTables()
-
 	// create folders if not exist
 	app.initFolders()
 
@@ -69,17 +92,30 @@ func Run() App {
 	torrentConfig := torrent.NewDefaultClientConfig()
 
 	torrentConfig.DataDir = config.DirBot + "/torrent-client"
-	torrentConfig.NoUpload = true
 	torrentConfig.DownloadRateLimiter = rate.NewLimiter(rate.Limit(config.DownloadLimit), config.DownloadLimit)
+	// without the upload limit the bot is only a leecher
+	if config.UploadLimit > 0 {
+		torrentConfig.Seed = true
+		torrentConfig.UploadRateLimiter = rate.NewLimiter(rate.Limit(config.UploadLimit), config.UploadLimit)
+	} else {
+		torrentConfig.NoUpload = true
+	}
 
 	app.TorClient, err = torrent.NewClient(torrentConfig)
-	//defer app.TorClient.Close()
 
 	if err != nil {
 		log.Panic(err)
 		os.Exit(1)
 	}
 
+	app.Seeder = NewSeeder(app)
+	go app.Seeder.Run(app.Ctx)
+
+	go app.Janitor(app.Ctx)
+
+	app.Disk = &DiskBudget{App: app}
+	go app.Disk.Run(app.Ctx)
+
 	// check nvenc
 	ch := Convert{}.healthNvenc()
 	if !ch {
@@ -91,10 +127,12 @@ func Run() App {
 }
 
 func (a *App) ObserverQueue() {
-	var cleanerWait sync.WaitGroup
 	for val := range a.Queue {
-		// set language
+		// set language, the language of the settings is for the commands
 		translate := &Translate{Code: val.Message.From.LanguageCode}
+		if strings.HasPrefix(val.Message.Text, "/") {
+			translate = LoadSettings(val.Message.From.ID).Translate(val.Message.From.LanguageCode)
+		}
 
 		// commands
 		if strings.Contains(val.Message.Text, "/start") {
@@ -117,30 +155,25 @@ func (a *App) ObserverQueue() {
 				}
 			}
 		}
+		if val.Message.Text == "/tasks" || val.Message.Text == "/queue" {
+			a.SendTasks(val.Message.Chat.ID, translate)
+			continue
+		}
 		if val.Message.Text == "/info" {
 			a.WelcomeMessage(val.Message, translate)
 		}
+		if val.Message.Text == "/settings" {
+			a.SendSettings(val.Message)
+			continue
+		}
 		if val.Message.Text == "/support" {
 			a.Bot.Send(tgbotapi.NewMessage(val.Message.Chat.ID,
 				translate.Lang("What is happened? Write me right here")))
 			continue
 		}
-		if val.Message.Text == "/stop" {
-			a.ChatsWork.chat.Range(func(key, _ any) bool {
-				if key == val.Message.Chat.ID {
-					a.ChatsWork.StopTasks.Store(val.Message.Chat.ID, true)
-				}
-
-				return true
-			})
-
-			a.TorrentChatsWork.chat.Range(func(key, _ any) bool {
-				if key == val.Message.Chat.ID {
-					a.ChatsWork.StopTasks.Store(val.Message.Chat.ID, true)
-				}
-
-				return true
-			})
+		if val.Message.Text == "/stop" || strings.HasPrefix(val.Message.Text, "/stop ") ||
+			strings.HasPrefix(val.Message.Text, "/stop_") {
+			a.StopTasks(val.Message, translate)
 
 			continue
 		}
@@ -153,37 +186,37 @@ func (a *App) ObserverQueue() {
 		}
 
 		// long task
-		torrentProcess, _ := a.ChatsWork.TorrentProcesses.Load(val.Message.Text)
 		if !(val.Message.Document != nil ||
 			strings.HasPrefix(val.Message.Text, "https://") ||
 			strings.Contains(val.Message.Text, "magnet:?xt=") ||
-			torrentProcess != nil) {
+			val.Torrent != nil) {
+			continue
+		}
+
+		// the bot is shutting down, the job stays in the db and is resumed after restart
+		if a.draining.Load() {
 			continue
 		}
 
-		// lock when files are deleting
-		a.LockForRemove.Wait()
-		cleanerWait.Add(1)
+		a.running.Add(1)
 
 		go func(valIn QueueMessages) {
-			defer cleanerWait.Done()
+			defer a.running.Done()
+
+			job := valIn.Job
 
 			// if fatal, execute cleaning
 			defer func(vi QueueMessages) {
 				if r := recover(); r != nil {
-					// global queue
-					a.ChatsWork.IncMinus(vi.Message.MessageID, vi.Message.Chat.ID)
-					a.TorrentChatsWork.IncMinus(vi.Message.MessageID, vi.Message.Chat.ID)
+					if job != nil {
+						job.Fail("Something wrong... I will be fixing it", fmt.Sprintf("panic: %s", r))
+					}
 
 					log.Infof("%+v", errors.WithStack(errors.New("Stacktrace")))
 					log.Errorf("Crash queue: %s", r)
 				}
 			}(valIn)
 
-			if !a.TaskAllowed(valIn.Message.Chat.ID, translate) {
-				return
-			}
-
 			var userFromDB User
 			_ = Postgres.Get(&userFromDB, `SELECT telegram_id, premium, language_code FROM users
                                            			WHERE telegram_id = $1`,
@@ -193,88 +226,154 @@ func (a *App) ObserverQueue() {
 				a.Bot.Send(tgbotapi.NewMessage(valIn.Message.From.ID,
 					translate.Lang("Please, send me /start command")))
 				a.SendLogToChannel(valIn.Message.From, "mess", "‼️ please, use /start command")
+				if valIn.Torrent != nil {
+					a.Seeder.Release(valIn.Torrent)
+				}
 				return
 			}
 
-			task := Task{Message: valIn.Message, App: a, UserFromDB: userFromDB, Translate: translate}
-			task.Translate.Code = userFromDB.LanguageCode
-
-			if (valIn.Message.Document != nil && valIn.Message.Document.MimeType == "application/x-bittorrent") ||
-				strings.Contains(valIn.Message.Text, "magnet:?xt=") {
-				if tp := task.OpenKeyBoardWithTorrentFiles(); tp != nil {
-					torrentProcess = tp
+			task := NewTask(a, valIn.Message, userFromDB, translate)
+			task.Settings = LoadSettings(userFromDB.TelegramID)
+			task.Translate.Code = task.Settings.Translate(userFromDB.LanguageCode).Code
+
+			// the workspace is removed with the task, also after the panic
+			if err := task.OpenWorkspace(); err != nil {
+				log.Error(err)
+				// the new message has no job yet, the user is told anyway
+				task.Job = job
+				if task.Job == nil {
+					task.Job = &Job{State: JobQueued}
 				}
+				task.Fail(err)
+				if valIn.Torrent != nil {
+					a.Seeder.Release(valIn.Torrent)
+				}
+				task.cancel(nil)
+				return
 			}
-
-			if torrentProcess != nil {
-				// torrent queue
-				a.TorrentChatsWork.IncPlus(valIn.Message.MessageID, valIn.Message.Chat.ID)
-
-				task.CloseKeyBoardWithTorrentFiles()
-				t := &ObjectTorrent{
-					Task:           &task,
-					TorrentProcess: torrentProcess.(*torrent.Torrent),
+			defer task.Cleaner()
+
+			// the bad flag is told before the torrent is added or the job is created
+			if err := task.ValidateOptions(); err != nil {
+				te := AsTaskError(err)
+				task.Send(tgbotapi.NewMessage(valIn.Message.Chat.ID, "😔 "+task.Lang(te.Key)+te.Hint))
+				a.SendLogToChannel(valIn.Message.From, "mess", "bad flags - "+err.Error())
+				if valIn.Torrent != nil {
+					a.Seeder.Release(valIn.Torrent)
 				}
-				task.Run(t)
+				task.cancel(nil)
+				return
+			}
 
-				// torrent queue
-				a.TorrentChatsWork.IncMinus(valIn.Message.MessageID, valIn.Message.Chat.ID)
+			if valIn.Torrent != nil {
+				task.Torrent.Process = valIn.Torrent
+			} else if (valIn.Message.Document != nil &&
+				valIn.Message.Document.MimeType == "application/x-bittorrent") ||
+				strings.Contains(valIn.Message.Text, "magnet:?xt=") {
+				// the job starts when the files are chosen, a torrent with one file starts now
+				task.Torrent.Process = task.OpenTorrentPicker()
+				if task.Torrent.Process == nil {
+					task.cancel(nil)
+					return
+				}
+			}
+			// the job of the playlist starts when the videos are chosen
+			if _, chosen := ParsePlaylistChoice(valIn.Message.Text); IsPlaylistUrl(valIn.Message.Text) && !chosen {
+				task.OpenPlaylistPicker()
+				task.cancel(nil)
+				return
+			}
+			// the torrent is acquired when it is added, it is seeded or dropped after the job
+			if task.Torrent.Process != nil {
+				defer a.Seeder.Release(task.Torrent.Process)
 			}
 
-			if strings.HasPrefix(valIn.Message.Text, "https://") {
-				// global queue
-				a.ChatsWork.IncPlus(valIn.Message.MessageID, valIn.Message.Chat.ID)
+			task.Source = MatchSource(task)
+			// the job of the youtube video starts when the quality is chosen, the resumed job has it
+			if job == nil && task.AskFormat() {
+				task.OpenFormatPicker()
+				task.cancel(nil)
+				return
+			}
+			if task.Source == nil && strings.HasPrefix(valIn.Message.Text, "https://") {
+				m := tgbotapi.NewMessage(valIn.Message.Chat.ID,
+					"❗️ "+task.Lang("Not allowed url, I support only")+":\n"+SupportedHosts())
+				m.DisableWebPagePreview = true
+				task.Send(m)
+				a.SendLogToChannel(valIn.Message.From, "mess", "not allowed url - "+valIn.Message.Text)
+			}
 
-				if strings.Contains(valIn.Message.Text, "https://open.spotify.com/track/") ||
-					strings.Contains(valIn.Message.Text, "https://open.spotify.com/album") {
-					ym := &ObjectSpotify{
-						Task: &task,
-					}
-					task.Run(ym)
-				} else {
-					vuc := &ObjectVideoUrl{
-						Task: &task,
-					}
-					task.Run(vuc)
+			if task.Source != nil {
+				if job == nil {
+					job = NewJob(valIn.Message, task.Source.Name, task.Torrent.Process)
 				}
-
-				// global queue
-				a.ChatsWork.IncMinus(valIn.Message.MessageID, valIn.Message.Chat.ID)
+				task.Job = job
+
+				// queue, extra tasks of the user wait for his own
+				task.Ticket = a.Scheduler.Enqueue(&Ticket{
+					Class:     task.Source.Class,
+					JobID:     job.ID,
+					UserID:    valIn.Message.From.ID,
+					ChatID:    valIn.Message.Chat.ID,
+					Premium:   userFromDB.Premium == 1,
+					UserLimit: task.UserTasksLimit(),
+				})
+
+				a.Tasks.Store(job.ID, task)
+				task.Run(task.Source.New(task))
+				a.Tasks.Delete(job.ID)
 			}
 
-			if _, bo := task.App.ChatsWork.StopTasks.LoadAndDelete(task.Message.Chat.ID); bo {
+			if task.StoppedByUser() {
 				task.Send(tgbotapi.NewMessage(task.Message.Chat.ID, "❗️ "+task.Lang("Task stopped")))
 			}
+			task.cancel(nil)
 
 			// send ad
 			go a.SendAd(valIn.Message)
-
-			go func(t Task) {
-				cleanerWait.Wait()
-				t.Cleaner()
-			}(task)
 		}(val)
 	}
 }
 
-func (a *App) TaskAllowed(chatId int64, tr *Translate) bool {
-	if _, bo := a.ChatsWork.chat.Load(chatId); bo {
-		_, err := a.Bot.Send(tgbotapi.NewMessage(chatId, "❗️ "+tr.Lang("Allowed only one task")))
+// StopTasks - /stop stops all tasks of the chat, /stop 12 or /stop_12 only the task 12
+func (a *App) StopTasks(message *tgbotapi.Message, tr *Translate) {
+	arg := strings.TrimSpace(strings.TrimLeft(strings.TrimPrefix(message.Text, "/stop"), "_ "))
+
+	var jobID int64
+	if arg != "" {
+		id, err := strconv.ParseInt(arg, 10, 64)
 		if err != nil {
-			log.Error(err)
+			a.Bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❗️ "+tr.Lang("Task not found")))
+			return
 		}
-		return false
+		jobID = id
 	}
 
-	if _, bo := a.TorrentChatsWork.chat.Load(chatId); bo {
-		_, err := a.Bot.Send(tgbotapi.NewMessage(chatId, "❗️ "+tr.Lang("Allowed only one task")))
-		if err != nil {
-			log.Error(err)
-		}
-		return false
+	if !a.StopTask(message.Chat.ID, message.From.ID, jobID) && jobID != 0 {
+		a.Bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❗️ "+tr.Lang("Task not found")))
 	}
+}
+
+// StopTask stops the task of the user in the chat, jobID 0 - all tasks of the user in the chat,
+// the tasks of other members of the group are kept
+func (a *App) StopTask(chatID int64, userID int64, jobID int64) bool {
+	var found bool
+	a.Tasks.Range(func(_, val any) bool {
+		task := val.(*Task)
+		if task.Message.Chat.ID != chatID || task.Message.From.ID != userID {
+			return true
+		}
+		if jobID != 0 && task.Job.ID != jobID {
+			return true
+		}
 
-	return true
+		task.Stop()
+		found = true
+
+		return true
+	})
+
+	return found
 }
 
 func (a *App) SendAd(mess *tgbotapi.Message) {
@@ -366,16 +465,13 @@ func (a *App) WelcomeMessage(message *tgbotapi.Message, tr *Translate) {
 		tr.Lang("And also you can send me") + " `magnet:?xt=` 🔗 magnet link"
 	a.Bot.Send(video)
 
-	preMess := tr.Lang("Or send me YouTube, TikTok url, examples below") + " 🫡\n\n" +
-		tr.Lang("Example") + " YouTube:\n https://www.youtube.com/watch?v=XqwbqxzsA2g\n" +
-		tr.Lang("Example") + " TikTok:\n https://vt.tiktok.com/ZS8EYpxHP\n" +
-		tr.Lang("Example") + " VK Video:\n https://vk.com/video-118281792_456242739\n" +
-		tr.Lang("Example") + " Twitch Clip: https://www.twitch.tv/guhrl/clip/CrowdedCrowdedClintCharlietheUnicor" +
-		"n-igG_XEcFiBw2KoVX\n" +
-		tr.Lang("Example") + " RuTube Video:\n https://rutube.ru/video/37b5e31d214ee0496e380a028c279c36\n" +
-		tr.Lang("Example") + " Spotify track:\n https://open.spotify.com/track/1hEh8Hc9lBAFWUghHBsCel\n" +
-		tr.Lang("Example") + " Spotify album:\n https://open.spotify.com/album/1YxUJdI0JWsXGGq8xa1SLt\n" +
-		tr.Lang("Example") + " Coub:\n https://coub.com/view/3bfclw\n"
+	preMess := tr.Lang("Or send me YouTube, TikTok url, examples below") + " 🫡\n\n" + SourceExamples(tr) +
+		"\n" + tr.Lang("Files bigger 2 GB in parts, add to the link or to the caption of the torrent file") +
+		"\n   +split\n" + tr.Lang("Convert torrent video while it is downloading") + "\n   +stream\n" +
+		tr.Lang("Only the audio of the video") + "\n   +audio\n" +
+		tr.Lang("The video if the audio is chosen in the settings") + "\n   +video\n" +
+		tr.Lang("Subtitles of the video, the language of yours or the chosen ones") + "\n   +subs  +subs=en,ru\n\n" +
+		tr.Lang("Quality, captions and notifications by default") + " - /settings"
 
 	var userFromDB User
 	_ = Postgres.Get(&userFromDB, "SELECT premium, language_code FROM users WHERE telegram_id = $1",
@@ -384,7 +480,8 @@ func (a *App) WelcomeMessage(message *tgbotapi.Message, tr *Translate) {
 		preMess += "\n\nPremium flags 🤫\n\n" +
 			"skip cache id\n   +skip-cache-id\n" +
 			"get max quality\n   +quality\n" +
-			"slice video, format hh:mm:ss\n   -ss 00:01:00 -to 00:10:00\n\n" +
+			"slice video, 90, 1:30, 01:02:03 or 1h2m3s\n   -ss 00:01:00 -to 00:10:00\n" +
+			"fix the broken video\n   +fixing-video\n\n" +
 			"Example: https://video.url +quality +..."
 	}
 
@@ -439,78 +536,3 @@ func PostgresConnect() *sqlx.DB {
 
 	return connect
 }
-
-func (a *App) initTables() {
-	if _, err := Postgres.Exec(`
-create table if not exists users
-(
-    telegram_id      bigint  		not null,
-    date_create      timestamp  	not null,
-    name			 text    		default '' not null,
-    premium		     int     		default 0 not null,
-  	sent_ad       	 int     		default 0 not null,
-  	block            int     		default 0 not null,
-	block_why 	     text 	    	default '' not null,
-	language_code    varchar(10)	default 'en'
-);
-create unique index if not exists users_telegram_id_uindex
-    on users (telegram_id);
-
-create table if not exists logs
-(
-	id      serial
-        	constraint logs_pk
-            primary key,
-    json             text 		default '' not null,
-    date_create 	 timestamp 	not null
-);
-
-create table if not exists cache
-(
-	id      serial
-        	constraint cache_pk
-            primary key,
-    caption				text 		default '' not null,
-    native_path_file	text 		default '' not null,
-    native_md5_sum		text 		default '' not null,
-    video_url_id		text 		default '' not null,
-    tg_from_id			text 		default '' not null,
-    tg_file_id			text 		default '' not null,
-    tg_file_size		int			default 0  not null,
-    date_create			timestamp	not null
-);
-create index if not exists cache_native_path_file_index
-    on cache (native_path_file);
-create index if not exists cache_native_md5_sum_index
-    on cache (native_md5_sum);
-create index if not exists cache_video_url_id_index
-    on cache (video_url_id);
-
-create table if not exists links
-(
-	id      serial
-        	constraint links_pk
-            primary key,
-    md5_url				text 	default '' not null,
-    url					text 	default '' not null,
-    telegram_id			bigint	not null,
-    date_create			text 	default '' not null
-);
-create index if not exists links_md5_url
-    on links (md5_url);
-
-create table if not exists limits
-(
-	id      serial
-        	constraint limits_pk
-            primary key,
-    type_object			text 		default '' not null,
-    telegram_id			bigint		not null,
-    date_create			timestamp 	not null
-);
-create index if not exists limits_group
-    on limits (type_object, telegram_id, date_create);
-`); err != nil {
-		log.Error(err)
-	}
-}
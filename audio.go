@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// coverMaxSize - the thumbnail bigger this isn't embedded
+const coverMaxSize = 10 << 20
+
+// AudioMeta - tags of the audio file
+type AudioMeta struct {
+	Title  string
+	Artist string
+	Cover  string
+}
+
+// AudioOnly - the +audio flag, the button under the progress or the settings without +video,
+// the audio of the video is sent
+func (t *Task) AudioOnly() bool {
+	o := t.Options()
+	if t.audio.Load() || o.Audio {
+		return true
+	}
+
+	return t.Settings.AudioOnly && !o.Video
+}
+
+// SwitchToAudio - the button under the progress, the flag is kept in the job for the resume
+func (t *Task) SwitchToAudio() {
+	if t.AudioOnly() {
+		return
+	}
+
+	t.audio.Store(true)
+	if t.Job != nil {
+		t.Job.AddFlag("+audio")
+	}
+}
+
+// audioCacheID - the audio and the video of the same url are cached apart
+func audioCacheID(urlID string) string {
+	if strings.HasSuffix(urlID, "-audio") {
+		return urlID
+	}
+
+	return urlID + "-audio"
+}
+
+// Audio extracts the audio of the file with the tags and the cover, aac is only remuxed into m4a,
+// the rest is transcoded into mp3
+func (c Convert) Audio(filePath string, meta AudioMeta) (string, error) {
+	folder, err := c.CreateFolderConvert("audio")
+	if err != nil {
+		return "", err
+	}
+
+	ffmpegPath := "./ffmpeg"
+	if config.IsDev {
+		ffmpegPath = "ffmpeg"
+	}
+
+	name := strings.TrimSuffix(path.Base(filePath), path.Ext(filePath))
+	args := []string{"-protocol_whitelist", "file", "-v", "error", "-i", filePath}
+
+	var cover string
+	if meta.Cover != "" {
+		cover = folder + "/cover"
+		if err := downloadCover(c.Task.Ctx, meta.Cover, cover); err != nil {
+			log.Warn(err)
+			cover = ""
+		}
+	}
+	if cover != "" {
+		args = append(args, "-i", cover)
+	}
+
+	args = append(args, "-map", "0:a:0")
+	if cover != "" {
+		args = append(args, "-map", "1:0", "-c:v", "mjpeg", "-disposition:v", "attached_pic")
+	}
+
+	fileOut := folder + "/" + name + ".mp3"
+	if c.audioCodec(filePath) == "aac" {
+		fileOut = folder + "/" + name + ".m4a"
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2", "-id3v2_version", "3")
+	}
+
+	args = append(args,
+		"-metadata", "title="+meta.Title,
+		"-metadata", "artist="+meta.Artist,
+		"-y", fileOut)
+
+	out, err := exec.CommandContext(c.Task.Ctx, ffmpegPath, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, out)
+	}
+
+	return fileOut, nil
+}
+
+func (c Convert) audioCodec(pathway string) string {
+	out, err := exec.CommandContext(c.Task.Ctx, "ffprobe",
+		"-protocol_whitelist", "file",
+		"-v", "error",
+		"-select_streams", "a:0",
+		"-show_entries", "stream=codec_name",
+		"-of", "csv=p=0",
+		pathway).Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func downloadCover(ctx context.Context, urlCover string, pathOut string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlCover, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cover %s - status %d", urlCover, resp.StatusCode)
+	}
+
+	file, err := os.Create(pathOut)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, io.LimitReader(resp.Body, coverMaxSize))
+
+	return err
+}
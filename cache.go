@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"database/sql"
 	"fmt"
+	"github.com/anacrolix/torrent/metainfo"
 	tgbotapi "github.com/krol44/telegram-bot-api"
 	log "github.com/sirupsen/logrus"
 	"os"
@@ -29,37 +30,117 @@ func (c Cache) Add(tgFileId string, tgFileSize int, nativeFilePath string) {
 		urlHttp = "\n" + c.Task.DescriptionUrl
 	}
 
-	if _, isSlice := c.Task.GetTimeSlice(); isSlice {
+	if c.Task.Options().Slice != nil {
 		nativeFilePath = ""
 		md5Sum = ""
 		c.Task.UrlIDForCache = "no"
 	}
 
+	// the file of the torrent is found by the info hash and the index, the path may differ
+	var (
+		infoHash  string
+		fileIndex = -1
+	)
+	if c.Task.Torrent.Process != nil && nativeFilePath != "" {
+		infoHash = c.Task.Torrent.Process.InfoHash().HexString()
+		fileIndex = TorrentFileIndex(c.Task.Torrent.Process, nativeFilePath)
+	}
+
 	_, err := Postgres.Exec(`INSERT INTO cache
-		(caption, native_path_file, native_md5_sum, video_url_id, tg_from_id, tg_file_id, tg_file_size, date_create)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		(caption, native_path_file, native_md5_sum, video_url_id, tg_from_id, tg_file_id, tg_file_size, date_create,
+		 variant, info_hash, file_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
 		caption+urlHttp, nativeFilePath, md5Sum, c.Task.UrlIDForCache, c.Task.Message.From.ID,
-		tgFileId, tgFileSize, time.Now())
+		tgFileId, tgFileSize, time.Now(), c.variant(c.Task.IsAllowFormatForConvert(nativeFilePath)),
+		infoHash, fileIndex)
 	if err != nil {
 		log.Error(err)
 	}
 }
 
+// variant - free users get the preview of torrent videos, the preview and the full video are cached apart
+func (c Cache) variant(isVideo bool) string {
+	if isVideo && c.Task.PreviewOnly() {
+		return "preview"
+	}
+
+	return ""
+}
+
+// TrySendTorrent - the file of the torrent was sent before, it is found by the info hash and the index,
+// the data of the torrent isn't needed. The file bigger 2 GB is sent in parts
+func (c Cache) TrySendTorrent(infoHash metainfo.Hash, index int, name string) bool {
+	// the slice isn't cached
+	if c.Task.Options().Slice != nil {
+		return false
+	}
+
+	isVideo := c.Task.IsAllowFormatForConvert(name)
+	// the source of the task isn't known yet when the torrent is looked up before it is added
+	var variant string
+	if isVideo && c.Task.PreviewUser() {
+		variant = "preview"
+	}
+
+	var rows []CacheRow
+	err := Postgres.Select(&rows, `SELECT caption, tg_file_id, native_path_file FROM cache
+		WHERE info_hash = $1 AND file_index = $2 AND variant = $3 ORDER BY id DESC`,
+		infoHash.HexString(), index, variant)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	if len(rows) == 0 {
+		return false
+	}
+
+	// the last send was in parts
+	if cut := strings.LastIndex(rows[0].NativePathFile, ".part"); cut != -1 {
+		nativePath := rows[0].NativePathFile[:cut]
+		if _, _, ok := ParsePartKey(nativePath, rows[0].NativePathFile); ok {
+			return c.sendParts(rows, nativePath, isVideo)
+		}
+	}
+
+	row := rows[0]
+	var sob tgbotapi.Chattable
+	if isVideo {
+		video := tgbotapi.NewVideo(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
+		video.Caption = c.Task.Caption(row.Caption)
+		c.Task.Deliver(&video.BaseChat, true)
+		sob = video
+	} else {
+		doc := tgbotapi.NewDocument(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
+		doc.Caption = c.Task.Caption(row.Caption)
+		c.Task.Deliver(&doc.BaseChat, true)
+		sob = doc
+	}
+
+	if _, err := c.Task.App.Bot.Send(sob); err != nil {
+		log.Error(err)
+		return false
+	}
+
+	c.Task.App.SendLogToChannel(c.Task.Message.From, "mess",
+		"torrent file sent from cache - "+row.Caption)
+
+	return true
+}
+
 func (c Cache) TrySend(typeSome string, pathway string) bool {
 	var row CacheRow
 	err := Postgres.Get(&row,
-		"SELECT caption, tg_file_id, native_path_file FROM cache WHERE native_path_file = $1 ORDER BY id DESC",
-		pathway)
+		`SELECT caption, tg_file_id, native_path_file FROM cache WHERE native_path_file = $1 AND variant = $2
+		ORDER BY id DESC`,
+		pathway, c.variant(typeSome == "video"))
 	if err != nil {
 		return false
 	}
 
 	if typeSome == "video" {
 		sob := tgbotapi.NewVideo(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
-		sob.Caption = row.Caption + signAdvt
-		if strings.Contains(row.NativePathFile, "torrent-client") {
-			sob.ProtectContent = true
-		}
+		sob.Caption = c.Task.Caption(row.Caption)
+		c.Task.Deliver(&sob.BaseChat, strings.Contains(row.NativePathFile, "torrent-client"))
 
 		_, err := c.Task.App.Bot.Send(sob)
 		if err != nil {
@@ -70,10 +151,8 @@ func (c Cache) TrySend(typeSome string, pathway string) bool {
 	}
 	if typeSome == "doc" {
 		sob := tgbotapi.NewDocument(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
-		sob.Caption = row.Caption + signAdvt
-		if strings.Contains(row.NativePathFile, "torrent-client") {
-			sob.ProtectContent = true
-		}
+		sob.Caption = c.Task.Caption(row.Caption)
+		c.Task.Deliver(&sob.BaseChat, strings.Contains(row.NativePathFile, "torrent-client"))
 
 		_, err := c.Task.App.Bot.Send(sob)
 		if err != nil {
@@ -99,7 +178,7 @@ func (c Cache) GetFileIdThroughMd5(NativeFilePath string) string {
 
 	var row CacheRow
 	err := Postgres.Get(&row,
-		"SELECT tg_file_id FROM cache WHERE native_md5_sum = $1 ORDER BY id DESC", md5Sum)
+		"SELECT tg_file_id FROM cache WHERE native_md5_sum = $1 AND variant = '' ORDER BY id DESC", md5Sum)
 	if err != nil {
 		return ""
 	}
@@ -119,17 +198,16 @@ func (c Cache) TrySendThroughMd5(NativeFilePath string) bool {
 
 	var row CacheRow
 	err := Postgres.Get(&row,
-		"SELECT caption, tg_file_id, native_path_file FROM cache WHERE native_md5_sum = $1 ORDER BY id DESC",
-		md5Sum)
+		`SELECT caption, tg_file_id, native_path_file FROM cache WHERE native_md5_sum = $1 AND variant = $2
+		ORDER BY id DESC`,
+		md5Sum, c.variant(true))
 	if err != nil {
 		return false
 	}
 
 	sob := tgbotapi.NewVideo(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
-	sob.Caption = row.Caption + signAdvt
-	if strings.Contains(row.NativePathFile, "torrent-client") {
-		sob.ProtectContent = true
-	}
+	sob.Caption = c.Task.Caption(row.Caption)
+	c.Task.Deliver(&sob.BaseChat, strings.Contains(row.NativePathFile, "torrent-client"))
 
 	_, err = c.Task.App.Bot.Send(sob)
 	if err != nil {
@@ -144,7 +222,8 @@ func (c Cache) TrySendThroughMd5(NativeFilePath string) bool {
 func (c Cache) TrySendThroughID() bool {
 	var row CacheRow
 	err := Postgres.Get(&row,
-		"SELECT caption, tg_file_id FROM cache WHERE video_url_id = $1 ORDER BY id DESC", c.Task.UrlIDForCache)
+		"SELECT caption, tg_file_id FROM cache WHERE video_url_id = $1 AND variant = '' ORDER BY id DESC",
+		c.Task.UrlIDForCache)
 	if err != nil && err != sql.ErrNoRows {
 		log.Error(err)
 		return false
@@ -154,8 +233,18 @@ func (c Cache) TrySendThroughID() bool {
 		return false
 	}
 
-	sob := tgbotapi.NewVideo(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
-	sob.Caption = row.Caption + signAdvt
+	var sob tgbotapi.Chattable
+	if c.Task.AudioOnly() {
+		audio := tgbotapi.NewAudio(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
+		audio.Caption = c.Task.Caption(row.Caption)
+		c.Task.Deliver(&audio.BaseChat, false)
+		sob = audio
+	} else {
+		video := tgbotapi.NewVideo(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
+		video.Caption = c.Task.Caption(row.Caption)
+		c.Task.Deliver(&video.BaseChat, false)
+		sob = video
+	}
 
 	_, err = c.Task.App.Bot.Send(sob)
 	if err != nil {
@@ -163,8 +252,84 @@ func (c Cache) TrySendThroughID() bool {
 		return false
 	}
 
-	c.Task.App.SendLogToChannel(c.Task.Message.From, "video",
-		"video sent from cache video url id - "+row.Caption, row.TgFileID)
+	if c.Task.AudioOnly() {
+		c.Task.App.SendLogToChannel(c.Task.Message.From, "mess",
+			"audio sent from cache video url id - "+row.Caption)
+	} else {
+		c.Task.App.SendLogToChannel(c.Task.Message.From, "video",
+			"video sent from cache video url id - "+row.Caption, row.TgFileID)
+	}
+
+	return true
+}
+
+// TrySendParts - the file bigger 2 GB was sent in parts before, it is sent from the cache
+// only if every part of the last split is there
+func (c Cache) TrySendParts(pathway string) bool {
+	isVideo := c.Task.IsAllowFormatForConvert(pathway)
+	// the parts are of the full video
+	if c.variant(isVideo) != "" {
+		return false
+	}
+
+	var rows []CacheRow
+	err := Postgres.Select(&rows, `SELECT caption, tg_file_id, native_path_file FROM cache
+		WHERE substr(native_path_file, 1, length($1)) = $1 AND variant = '' ORDER BY id DESC`, pathway+".part")
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+
+	return c.sendParts(rows, pathway, isVideo)
+}
+
+// sendParts - every part of the last split of the file is sent
+func (c Cache) sendParts(rows []CacheRow, pathway string, isVideo bool) bool {
+	var (
+		parts = map[int]CacheRow{}
+		total int
+	)
+	for _, row := range rows {
+		part, count, ok := ParsePartKey(pathway, row.NativePathFile)
+		if !ok {
+			continue
+		}
+		if total == 0 {
+			total = count
+		}
+		if _, exists := parts[part]; count == total && !exists {
+			parts[part] = row
+		}
+	}
+	if total == 0 || len(parts) != total {
+		return false
+	}
+
+	for i := 1; i <= total; i++ {
+		row := parts[i]
+		caption := c.Task.PartCaption(i, total) + "\n" + c.Task.Caption(row.Caption)
+
+		var sob tgbotapi.Chattable
+		if isVideo {
+			video := tgbotapi.NewVideo(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
+			video.Caption = caption
+			c.Task.Deliver(&video.BaseChat, strings.Contains(row.NativePathFile, "torrent-client"))
+			sob = video
+		} else {
+			doc := tgbotapi.NewDocument(c.Task.Message.Chat.ID, tgbotapi.FileID(row.TgFileID))
+			doc.Caption = caption
+			c.Task.Deliver(&doc.BaseChat, strings.Contains(row.NativePathFile, "torrent-client"))
+			sob = doc
+		}
+
+		if _, err := c.Task.App.Bot.Send(sob); err != nil {
+			log.Error(err)
+			return false
+		}
+	}
+
+	c.Task.App.SendLogToChannel(c.Task.Message.From, "mess",
+		fmt.Sprintf("%d parts sent from cache - %s", total, path.Base(pathway)))
 
 	return true
 }
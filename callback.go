@@ -0,0 +1,69 @@
+package main
+
+import (
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// CallbackQuery handles inline keyboard buttons, data format - action:argument
+func (a *App) CallbackQuery(cq *tgbotapi.CallbackQuery) {
+	if cq.Message == nil || cq.Message.Chat == nil {
+		return
+	}
+
+	tr := &Translate{Code: cq.From.LanguageCode}
+	action, arg, _ := strings.Cut(cq.Data, ":")
+
+	var answer string
+	switch action {
+	case "cancel", "stop":
+		// cancel - the button of the task list, stop - the button under the progress message,
+		// only the owner of the task stops it
+		jobID, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || !a.StopTask(cq.Message.Chat.ID, cq.From.ID, jobID) {
+			answer = tr.Lang("Task not found")
+			break
+		}
+
+		answer = tr.Lang("Task stopped")
+		if action == "cancel" {
+			a.RefreshTasks(cq.Message.Chat.ID, cq.Message.MessageID, tr)
+		}
+	case "audio":
+		// the video url is sent as audio, the button is under the progress message
+		jobID, _ := strconv.ParseInt(arg, 10, 64)
+		val, ok := a.Tasks.Load(jobID)
+		if !ok {
+			answer = tr.Lang("Task not found")
+			break
+		}
+		task := val.(*Task)
+		if task.Message.Chat.ID != cq.Message.Chat.ID || task.Message.From.ID != cq.From.ID {
+			answer = tr.Lang("Task not found")
+			break
+		}
+
+		task.SwitchToAudio()
+		answer = tr.Lang("Only the audio will be sent")
+	case "tp":
+		// the torrent file picker, the argument is id:op:value
+		answer = a.PickerCallback(cq, arg, "The list of files is expired, send the torrent again")
+	case "pl":
+		// the playlist picker, the argument is id:op:value
+		answer = a.PickerCallback(cq, arg, "The list of videos is expired, send the link again")
+	case "fp":
+		// the quality picker, the argument is id:op:value
+		answer = a.PickerCallback(cq, arg, "The list of formats is expired, send the link again")
+	case "st":
+		// the menu of /settings, the argument is the key of the setting
+		answer = a.SettingsCallback(cq, arg)
+	default:
+		log.Warn("unknown callback - " + cq.Data)
+	}
+
+	if _, err := a.Bot.Request(tgbotapi.NewCallback(cq.ID, answer)); err != nil {
+		log.Warn(err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path"
 	"runtime"
 	"strconv"
+	"time"
 )
 
 type Struct struct {
@@ -16,6 +17,8 @@ type Struct struct {
 
 	DirBot        string
 	DownloadLimit int
+	// UploadLimit - bytes per second, 0 - the upload is off
+	UploadLimit int
 
 	WelcomeFileId string
 
@@ -27,7 +30,28 @@ type Struct struct {
 	AllowVideoFormats []string
 
 	MaxTasks        int
+	MaxTasksSpotify int
 	MaxTasksTorrent int
+	MaxTasksConvert int
+	MaxTasksDirect  int
+
+	UserTasksFree    int
+	UserTasksPremium int
+
+	// videos of the playlist in one task
+	PlaylistMaxFree    int
+	PlaylistMaxPremium int
+
+	ShutdownTimeout time.Duration
+
+	// seeding after the job, until the ratio or the time, the seeded data is kept in the disk budget
+	SeedRatio      float64
+	SeedTime       time.Duration
+	SeedDiskBudget int64
+	// TorrentCacheSize - bytes of the data of the finished torrents kept on the disk for the next jobs
+	TorrentCacheSize int64
+	// DiskHighWater - percent of the volume, downloads wait while it would be used more
+	DiskHighWater int
 
 	CuteStickers []string
 }
@@ -37,12 +61,38 @@ var config Struct
 func init() {
 	dl, _ := strconv.Atoi(os.Getenv("DOWNLOAD_LIMIT"))
 	chatIdChannelLog, _ := strconv.ParseInt(os.Getenv("CHAT_ID_CHANNEL_LOG"), 10, 64)
+	shutdownTimeout, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || shutdownTimeout <= 0 {
+		shutdownTimeout = 300
+	}
+	ul, _ := strconv.Atoi(os.Getenv("UPLOAD_LIMIT"))
+	seedRatio, err := strconv.ParseFloat(os.Getenv("SEED_RATIO"), 64)
+	if err != nil || seedRatio <= 0 {
+		seedRatio = 1
+	}
+	seedTime, err := strconv.Atoi(os.Getenv("SEED_TIME"))
+	if err != nil || seedTime <= 0 {
+		seedTime = 60
+	}
+	seedDiskBudget, err := strconv.ParseInt(os.Getenv("SEED_DISK_BUDGET"), 10, 64)
+	if err != nil || seedDiskBudget <= 0 {
+		seedDiskBudget = 20e9
+	}
+	torrentCacheSize, err := strconv.ParseInt(os.Getenv("TORRENT_CACHE_SIZE"), 10, 64)
+	if err != nil || torrentCacheSize < 0 {
+		torrentCacheSize = 50e9
+	}
+	diskHighWater, err := strconv.Atoi(os.Getenv("DISK_HIGH_WATER"))
+	if err != nil || diskHighWater <= 0 || diskHighWater > 100 {
+		diskHighWater = 90
+	}
 
 	config = Struct{
 		os.Getenv("DEV") == "true",
 		chatIdChannelLog,
 		os.Getenv("DIR_BOT"),
 		dl,
+		ul,
 		os.Getenv("WELCOME_VIDEO_ID"),
 		os.Getenv("BOT_DEBUG") == "true",
 		os.Getenv("BOT_TOKEN"),
@@ -51,6 +101,19 @@ func init() {
 		[]string{".avi", ".mkv", ".mp4", ".m4v", ".flv", ".ts", ".mov", ".wmv", ".webm", ".3gp"},
 		2,
 		1,
+		1,
+		2,
+		2,
+		1,
+		3,
+		5,
+		50,
+		time.Duration(shutdownTimeout) * time.Second,
+		seedRatio,
+		time.Duration(seedTime) * time.Minute,
+		seedDiskBudget,
+		torrentCacheSize,
+		diskHighWater,
 		[]string{
 			"CAACAgIAAxkBAAIEW2OcfHb7yPa6z59rHlFiTTUTkA3XAAJ-GQACHiDBS43V6msCr8MXKwQ",
 			"CAACAgIAAxkBAAIRfWOreMzwPkQDC4jYKGUTeCxNO3TuAAJ3GAAC24IRSEjXhoRmKkUtKwQ",
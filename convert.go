@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,6 +21,9 @@ import (
 type Convert struct {
 	Task      *Task
 	IsTorrent bool
+	// Input - url of the stream instead of the file, Downloaded - percent of the downloaded stream
+	Input      string
+	Downloaded func() float64
 }
 
 type FileConverted struct {
@@ -30,17 +34,23 @@ type FileConverted struct {
 	CoverSize      image.Point
 }
 
-func (c Convert) Run() FileConverted {
+func (c Convert) Run() (FileConverted, error) {
 	fileConvertPath := c.Task.File
 	c.Task.File = ""
 
-	infoVideo := c.GetInfoVideo(fileConvertPath)
+	// the file is read from the stream while it is downloading
+	input := fileConvertPath
+	if c.Input != "" {
+		input = c.Input
+	}
+
+	infoVideo := c.GetInfoVideo(input)
 	bitrate, _ := strconv.Atoi(infoVideo.Format.BitRate)
 
 	fileName := strings.TrimSuffix(path.Base(fileConvertPath), path.Ext(path.Base(fileConvertPath)))
 
 	c.Task.App.SendLogToChannel(c.Task.Message.From, "mess", "start convert")
-	_, _ = c.Task.App.Bot.Send(tgbotapi.NewEditMessageText(c.Task.Message.Chat.ID, c.Task.MessageEditID,
+	_, _ = c.Task.App.Bot.Send(c.Task.EditProgress(
 		fmt.Sprintf("🌪 %s \n\n🔥 "+c.Task.Lang("Convert is starting")+"...", fileName)))
 
 	// create folder
@@ -53,18 +63,23 @@ func (c Convert) Run() FileConverted {
 	fileCoverPath := pathwayNewFile + ".jpg"
 	fileConvertPathOut := pathwayNewFile + ".mp4"
 
-	timeTotal := c.TimeTotalRaw(fileConvertPath)
+	timeTotal := c.TimeTotalRaw(input)
 
 	var err error
 
-	var forceLowBConvert bool
-	if strings.Contains(c.Task.Message.Text, "+fixing-video") {
-		forceLowBConvert = true
+	forceLowBConvert := c.Task.Options().FixVideo
+	isSlice := c.Task.Options().Slice != nil
+
+	// tier policy, free users get the preview of torrent videos
+	preview := c.IsTorrent && c.Task.PreviewOnly()
+	if preview {
+		isSlice = false
+		timeTotal = previewTimeTotal(timeTotal)
 	}
 
-	_, isSlice := c.Task.GetTimeSlice()
 	// check for mp4
-	if path.Ext(fileConvertPath) == ".mp4" && forceLowBConvert == false && isSlice == false {
+	if path.Ext(fileConvertPath) == ".mp4" && forceLowBConvert == false && isSlice == false && !preview &&
+		c.Input == "" {
 		c.Task.App.SendLogToChannel(c.Task.Message.From, "mess", "ext .mp4 - skip convert")
 		fileConvertPathOut = fileConvertPath
 	} else {
@@ -72,16 +87,30 @@ func (c Convert) Run() FileConverted {
 			bitrate = 1500
 		}
 
-		err := c.execConvert(bitrate, timeTotal, fileName, fileConvertPath, fileConvertPathOut)
+		ticket := c.Task.App.Scheduler.Enqueue(&Ticket{
+			Class:   "convert",
+			JobID:   c.Task.Job.ID,
+			UserID:  c.Task.Message.From.ID,
+			ChatID:  c.Task.Message.Chat.ID,
+			Premium: c.Task.UserFromDB.Premium == 1,
+		})
+		allowed := c.Task.Wait(ticket, func(position int, eta time.Duration) {
+			_, _ = c.Task.App.Bot.Send(c.Task.EditProgress(
+				fmt.Sprintf("🌪 %s \n\n🔥 "+c.Task.Lang("Convert is starting")+"...\n\n%s",
+					fileName, c.Task.QueueText(position, eta))))
+		})
+		if !allowed {
+			return FileConverted{}, c.Task.StopError()
+		}
+
+		err := c.execConvert(bitrate, timeTotal, fileName, input, fileConvertPathOut, preview)
+		c.Task.App.Scheduler.Release(ticket)
 		if err != nil {
-			if _, bo := c.Task.App.ChatsWork.StopTasks.Load(c.Task.Message.Chat.ID); bo {
-				return FileConverted{}
+			if c.Task.Stopped() {
+				return FileConverted{}, c.Task.StopError()
 			}
 
-			c.Task.Send(tgbotapi.NewMessage(
-				c.Task.Message.Chat.ID, "❗️ "+c.Task.Lang("Video is bad")+" - "+fileName))
-			log.Error(err)
-			return FileConverted{}
+			return FileConverted{}, &TaskError{Key: "Video is bad", Detail: fileName, Err: err}
 		}
 	}
 
@@ -112,16 +141,15 @@ func (c Convert) Run() FileConverted {
 	// get size
 	sizeCover, err := c.GetSizeCover(fileCoverPath)
 	if err != nil {
-		log.Error(err)
-		return FileConverted{}
+		return FileConverted{}, &TaskError{Key: "Video is bad", Detail: "cover of " + fileName, Err: err}
 	}
 
 	return FileConverted{fileName, fileConvertPathOut, fileConvertPath,
-		fileCoverPath, sizeCover}
+		fileCoverPath, sizeCover}, nil
 }
 
 func (c Convert) execConvert(bitrate int, timeTotal time.Time, fileName string, fileConvertPath string,
-	fileConvertPathOut string) error {
+	fileConvertPathOut string, preview bool) error {
 	ffmpegPath := "./ffmpeg"
 	if config.IsDev {
 		ffmpegPath = "ffmpeg"
@@ -134,10 +162,10 @@ func (c Convert) execConvert(bitrate int, timeTotal time.Time, fileName string,
 		cv = "h264"
 	}
 
-	slice, isSlice := c.Task.GetTimeSlice()
+	slice := c.Task.Options().Slice
 
 	prepareArgs := []string{
-		"-protocol_whitelist", "file",
+		"-protocol_whitelist", protocols(fileConvertPath),
 		"-v", "quiet",
 		"-hide_banner", "-stats",
 		"-i", fileConvertPath,
@@ -145,7 +173,6 @@ func (c Convert) execConvert(bitrate int, timeTotal time.Time, fileName string,
 		"-c:v", cv,
 		"-filter_complex", "scale=w='min(1920\\, iw*3/2):h=-2'",
 		"-preset", "medium",
-		"-fs", "1990M",
 		"-pix_fmt", "yuv420p",
 		"-b:v", fmt.Sprintf("%d", bitrate),
 		"-b:a", "192k",
@@ -155,18 +182,32 @@ func (c Convert) execConvert(bitrate int, timeTotal time.Time, fileName string,
 
 	var args []string
 	for _, pa := range prepareArgs {
-		if pa == "-preset" && isSlice {
-			args = append(args, []string{"-ss", slice[0], "-to", slice[1]}...)
+		if pa == "-preset" && slice != nil {
+			args = append(args, slice.Args()...)
+		}
+		// the video bigger 2 GB is cut into parts after the convert
+		if pa == "-y" && !c.Task.SplitEnabled() {
+			args = append(args, []string{"-fs", "1990M"}...)
 		}
 		args = append(args, pa)
 	}
 
+	if preview {
+		var err error
+		if args, err = c.previewArgs(cv, bitrate, fileConvertPath, fileConvertPathOut); err != nil {
+			return err
+		}
+	}
+
 	log.Info(args)
 
 	tmpLast := ""
 
-	cmd := exec.Command(ffmpegPath, args...)
+	cmd := exec.CommandContext(c.Task.Ctx, ffmpegPath, args...)
 	defer func(c *exec.Cmd, t string) {
+		if c.Process == nil {
+			return
+		}
 		c.Process.Kill()
 		if err := c.Wait(); err != nil {
 			log.Info(t)
@@ -185,10 +226,6 @@ func (c Convert) execConvert(bitrate int, timeTotal time.Time, fileName string,
 	}
 
 	for {
-		if _, bo := c.Task.App.ChatsWork.StopTasks.Load(c.Task.Message.Chat.ID); bo {
-			return errors.New("force stop")
-		}
-
 		tmp := make([]byte, 1024)
 		_, err := stdout.Read(tmp)
 		if err != nil {
@@ -210,10 +247,15 @@ func (c Convert) execConvert(bitrate int, timeTotal time.Time, fileName string,
 
 		percentConvert, _ := strconv.ParseFloat(fmt.Sprintf("%.2f",
 			100-(timeTotal.Sub(timeLeft).Seconds()/timeTotal.Sub(timeNull).Seconds())*100), 64)
+		c.Task.SetProgress(percentConvert)
 
-		_, errEdit := c.Task.App.Bot.Send(tgbotapi.NewEditMessageText(c.Task.Message.Chat.ID, c.Task.MessageEditID,
-			fmt.Sprintf("🌪 %s \n\n🔥 "+c.Task.Lang("Convert progress")+": %.2f%%",
-				fileName, percentConvert)))
+		text := fmt.Sprintf("🌪 %s \n\n🔥 "+c.Task.Lang("Convert progress")+": %.2f%%",
+			fileName, percentConvert)
+		if c.Downloaded != nil {
+			text += fmt.Sprintf("\n🔽 "+c.Task.Lang("Download progress")+": %.2f%%", c.Downloaded())
+		}
+
+		_, errEdit := c.Task.App.Bot.Send(c.Task.EditProgress(text))
 
 		if errEdit != nil {
 			log.Warning(errEdit)
@@ -222,11 +264,24 @@ func (c Convert) execConvert(bitrate int, timeTotal time.Time, fileName string,
 		time.Sleep(2 * time.Second)
 	}
 
+	if c.Task.Stopped() {
+		return errors.New("force stop")
+	}
+
 	return nil
 }
 
+// protocols - ffmpeg reads only files, the stream of the torrent is read from the local server
+func protocols(input string) string {
+	if strings.HasPrefix(input, "http://127.0.0.1:") {
+		return "file,http,tcp"
+	}
+
+	return "file"
+}
+
 func (c Convert) CreateFolderConvert(fileName string) (string, error) {
-	folderConvert := config.DirBot + "/storage/" + c.Task.UniqueId("files-convert-"+
+	folderConvert := c.Task.Workspace + "/" + c.Task.UniqueId("files-convert-"+
 		fileName+"-"+strconv.FormatInt(c.Task.Message.From.ID, 10))
 	err := os.Mkdir(folderConvert, os.ModePerm)
 	if err != nil {
@@ -243,7 +298,7 @@ func (c Convert) CreateCover(videoFile string, fileCoverPath string, timeTotal t
 		timeCut = "00:00:01"
 	}
 
-	_, err := exec.Command("ffmpeg",
+	_, err := exec.CommandContext(c.Task.Ctx, "ffmpeg",
 		"-protocol_whitelist", "file",
 		"-i", videoFile,
 		"-ss", timeCut,
@@ -281,8 +336,8 @@ func (c Convert) GetSizeCover(fileCoverPath string) (image.Point, error) {
 }
 
 func (c Convert) TimeTotalRaw(pathway string) time.Time {
-	timeTotalRaw, err := exec.Command("ffprobe",
-		"-protocol_whitelist", "file",
+	timeTotalRaw, err := exec.CommandContext(c.Task.Ctx, "ffprobe",
+		"-protocol_whitelist", protocols(pathway),
 		"-v", "error",
 		"-show_entries", "format=duration",
 		"-of", "default=noprint_wrappers=1:nokey=1",
@@ -322,7 +377,7 @@ type InfoVideo struct {
 func (c Convert) GetInfoVideo(pathway string) InfoVideo {
 	var infoVideo InfoVideo
 
-	info, err := exec.Command("ffprobe",
+	info, err := exec.CommandContext(c.Task.Ctx, "ffprobe",
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_format", pathway).Output()
@@ -339,8 +394,17 @@ func (c Convert) GetInfoVideo(pathway string) InfoVideo {
 	return infoVideo
 }
 
+// ctx - the probes are killed with the task, the check of nvenc on start has no task
+func (c Convert) ctx() context.Context {
+	if c.Task == nil {
+		return context.Background()
+	}
+
+	return c.Task.Ctx
+}
+
 func (c Convert) healthNvenc() bool {
-	infoSmi, err := exec.Command("/usr/bin/nvidia-smi").Output()
+	infoSmi, err := exec.CommandContext(c.ctx(), "/usr/bin/nvidia-smi").Output()
 	if err != nil {
 		log.Warn(err)
 		return false
@@ -354,7 +418,7 @@ func (c Convert) healthNvenc() bool {
 	if config.IsDev {
 		ffmpegPath = "ffmpeg"
 	}
-	info, err := exec.Command(ffmpegPath,
+	info, err := exec.CommandContext(c.ctx(), ffmpegPath,
 		"-v", "quiet",
 		"-h", "encoder=h264_nvenc").Output()
 	if err != nil {
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DiskBudget - the expected sizes of the downloads are reserved before the start, a download waits
+// while the volume of the bot would be filled over the high-water mark. Postgres and the telegram api
+// server share the volume, they break if it is full
+type DiskBudget struct {
+	App *App
+
+	mu sync.Mutex
+	// admit - the reservations are checked one by one, the written sizes are walked without mu
+	admit        sync.Mutex
+	reservations map[*diskReservation]struct{}
+	waiting      int
+	// statfs - the usage of the volume, replaced in the tests
+	statfs func() (diskUsage, error)
+}
+
+// diskReservation - the written bytes are on the disk already, they are counted by the usage of the volume
+type diskReservation struct {
+	size    int64
+	written func() int64
+}
+
+// outstanding - the part of the reservation which isn't written yet
+func (r *diskReservation) outstanding() int64 {
+	if r.written == nil {
+		return r.size
+	}
+
+	written := r.written()
+	switch {
+	case written <= 0:
+		return r.size
+	case written >= r.size:
+		return 0
+	}
+
+	return r.size - written
+}
+
+type diskUsage struct {
+	Total int64
+	Used  int64
+}
+
+func (d *DiskBudget) usage() (diskUsage, error) {
+	if d.statfs != nil {
+		return d.statfs()
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(config.DirBot, &stat); err != nil {
+		return diskUsage{}, err
+	}
+
+	total := int64(stat.Blocks) * int64(stat.Bsize)
+	free := int64(stat.Bavail) * int64(stat.Bsize)
+
+	return diskUsage{Total: total, Used: total - free}, nil
+}
+
+// highWater - bytes of the volume which may be used
+func (u diskUsage) highWater() int64 {
+	return u.Total / 100 * int64(config.DiskHighWater)
+}
+
+// reserved - the bytes of the reservations which aren't written yet, the workspaces are walked without the lock
+func (d *DiskBudget) reserved() int64 {
+	d.mu.Lock()
+	rs := make([]*diskReservation, 0, len(d.reservations))
+	for r := range d.reservations {
+		rs = append(rs, r)
+	}
+	d.mu.Unlock()
+
+	var size int64
+	for _, r := range rs {
+		size += r.outstanding()
+	}
+
+	return size
+}
+
+// tryReserve - fits is false if the size doesn't fit now, the size never fits if nothing else is reserved
+func (d *DiskBudget) tryReserve(r *diskReservation) (fits bool, never bool, err error) {
+	usage, err := d.usage()
+	if err != nil {
+		return false, false, err
+	}
+
+	d.admit.Lock()
+	defer d.admit.Unlock()
+
+	reserved := d.reserved()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if usage.Used+reserved+r.size <= usage.highWater() {
+		if d.reservations == nil {
+			d.reservations = map[*diskReservation]struct{}{}
+		}
+		d.reservations[r] = struct{}{}
+		return true, false, nil
+	}
+
+	return false, len(d.reservations) == 0, nil
+}
+
+func (d *DiskBudget) release(r *diskReservation) {
+	d.mu.Lock()
+	delete(d.reservations, r)
+	d.mu.Unlock()
+}
+
+// Reserve - the expected size of the download, the task waits for the space without the slot of the scheduler,
+// the reservation is released with the cleanup of the task. Written - the bytes of the download on the disk,
+// nil - the growth of the workspace
+func (t *Task) Reserve(size int64, written func() int64) error {
+	if size <= 0 {
+		return nil
+	}
+	d := t.App.Disk
+
+	if written == nil {
+		base := dirSize(t.Workspace)
+		written = func() int64 {
+			return dirSize(t.Workspace) - base
+		}
+	}
+	r := &diskReservation{size: size, written: written}
+
+	var (
+		waiting  bool
+		stopHint string
+	)
+	defer func() {
+		if waiting {
+			d.mu.Lock()
+			d.waiting--
+			d.mu.Unlock()
+		}
+	}()
+
+	for {
+		fits, never, err := d.tryReserve(r)
+		if err != nil {
+			return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "disk usage", Err: err}
+		}
+		if fits {
+			t.reservations = append(t.reservations, r)
+			break
+		}
+		if never {
+			return &TaskError{Key: "Not enough disk space, please try later", Retryable: true,
+				Detail: "disk budget - " + humanize.Bytes(uint64(size))}
+		}
+
+		if !waiting {
+			waiting = true
+			d.mu.Lock()
+			d.waiting++
+			d.mu.Unlock()
+
+			// the slot is given to the runnable tasks while the disk is full
+			t.App.Scheduler.Release(t.Ticket)
+
+			t.App.SendLogToChannel(t.Message.From, "mess",
+				"waiting for disk space - "+humanize.Bytes(uint64(size)))
+			stopHint = fmt.Sprintf("\n\n⛔️ "+t.Lang("Stop the task")+": /stop_%d", t.Job.ID)
+			ms := "💽 " + t.Lang("Waiting for free disk space") + "..." + stopHint
+			t.Send(t.EditProgress(ms))
+			t.MessageTextLast = ms
+		}
+
+		select {
+		case <-t.Ctx.Done():
+			return t.StopError()
+		case <-time.After(10 * time.Second):
+		}
+	}
+
+	if !waiting {
+		return nil
+	}
+
+	// the slot is taken again, the reservation is kept in the queue
+	t.App.Scheduler.Enqueue(t.Ticket)
+	allowed := t.Wait(t.Ticket, func(position int, eta time.Duration) {
+		ms := "🍀 " + t.Lang("Download is starting soon") + "...\n\n" + t.QueueText(position, eta) + stopHint
+
+		if ms != t.MessageTextLast {
+			t.Send(t.EditProgress(ms))
+			t.MessageTextLast = ms
+		}
+	})
+	if !allowed {
+		return t.StopError()
+	}
+
+	return nil
+}
+
+// ReleaseReserve - the files of the task are removed, the reserved sizes are free
+func (t *Task) ReleaseReserve() {
+	for _, r := range t.reservations {
+		t.App.Disk.release(r)
+	}
+	t.reservations = nil
+}
+
+// Stats - usage of the volume and the reserved sizes
+func (d *DiskBudget) Stats() string {
+	usage, err := d.usage()
+	if err != nil {
+		return "💽 disk - " + err.Error()
+	}
+
+	reserved := d.reserved()
+	d.mu.Lock()
+	waiting := d.waiting
+	d.mu.Unlock()
+
+	var percent int64
+	if usage.Total > 0 {
+		percent = usage.Used * 100 / usage.Total
+	}
+
+	return fmt.Sprintf("💽 disk - used %s of %s (%d%%), high-water %d%%, reserved %s, waiting %d tasks",
+		humanize.Bytes(uint64(usage.Used)), humanize.Bytes(uint64(usage.Total)), percent,
+		config.DiskHighWater, humanize.Bytes(uint64(reserved)), waiting)
+}
+
+// Run sends the usage to the log channel every hour
+func (d *DiskBudget) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.App.SendLogToChannel(&tgbotapi.User{UserName: "disk"}, "mess", d.Stats())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDiskTryReserve(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.DiskHighWater = 90
+
+	used := int64(500)
+	d := &DiskBudget{statfs: func() (diskUsage, error) {
+		return diskUsage{Total: 1000, Used: used}, nil
+	}}
+
+	var written int64
+	first := &diskReservation{size: 300, written: func() int64 { return written }}
+	if fits, _, _ := d.tryReserve(first); !fits {
+		t.Fatal("300 of the free 400 don't fit")
+	}
+
+	second := &diskReservation{size: 150}
+	if fits, never, _ := d.tryReserve(second); fits || never {
+		t.Errorf("150 over the high-water - fits %v, never %v", fits, never)
+	}
+
+	// the written bytes are in the usage of the volume, they aren't counted twice
+	written, used = 200, 700
+	if got := d.reserved(); got != 100 {
+		t.Errorf("reserved %d after the written 200, want 100", got)
+	}
+	second.size = 100
+	if fits, _, _ := d.tryReserve(second); !fits {
+		t.Error("100 don't fit after the download of the first")
+	}
+
+	d.release(first)
+	d.release(second)
+	if fits, never, _ := d.tryReserve(&diskReservation{size: 500}); fits || !never {
+		t.Errorf("500 over the empty budget - fits %v, never %v", fits, never)
+	}
+}
+
+func TestDiskReservationOutstanding(t *testing.T) {
+	for written, want := range map[int64]int64{-50: 100, 0: 100, 40: 60, 100: 0, 150: 0} {
+		written := written
+		r := &diskReservation{size: 100, written: func() int64 { return written }}
+		if got := r.outstanding(); got != want {
+			t.Errorf("written %d - %d, want %d", written, got, want)
+		}
+	}
+}
+
+func TestTaskReserve(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.DiskHighWater = 90
+
+	d := &DiskBudget{statfs: func() (diskUsage, error) {
+		return diskUsage{Total: 1000, Used: 100}, nil
+	}}
+	task := &Task{App: &App{Disk: d}, Workspace: t.TempDir()}
+
+	if err := task.Reserve(300, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(task.Workspace+"/video.mp4", make([]byte, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.reserved(); got != 200 {
+		t.Errorf("reserved %d after the written 100, want 200", got)
+	}
+
+	task.ReleaseReserve()
+	if got := d.reserved(); got != 0 || task.reservations != nil {
+		t.Errorf("reserved %d after the release", got)
+	}
+
+	err := task.Reserve(2000, nil)
+	if te := AsTaskError(err); te.Key != "Not enough disk space, please try later" {
+		t.Errorf("the size over the volume - %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options - the url and the flags of the message, "https://video.url +quality -ss 1:00 -to 2:30".
+// The choices of the pickers are kept with the flags, the words of the torrent choice are in Args
+type Options struct {
+	Url       string
+	Quality   bool
+	Audio     bool
+	Video     bool
+	Split     bool
+	Stream    bool
+	SkipCache bool
+	FixVideo  bool
+	// Subtitles - the languages of the subtitles, "auto" is the language of the user
+	Subtitles string
+	Slice     *TimeSlice
+	Format    string
+	Playlist  []string
+	Args      []string
+}
+
+// TimeSlice - the part of the video, zero is the start or the end of the video
+type TimeSlice struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// SubtitlesAuto - +subs without the languages
+const SubtitlesAuto = "auto"
+
+var subtitlesLangs = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{1,8})*(,[a-zA-Z]{2,3}(-[a-zA-Z0-9]{1,8})*)*$`)
+
+// ParseOptions splits the text of the message into the url and the flags, the options are filled
+// till the first bad flag, the error is for the user
+func ParseOptions(text string) (Options, error) {
+	var o Options
+
+	fields := strings.Fields(text)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		switch {
+		case strings.HasPrefix(field, "+"):
+			name, value, hasValue := strings.Cut(strings.TrimPrefix(field, "+"), "=")
+			if hasValue && name != "subs" {
+				return o, &TaskError{Key: "Unknown flag", Hint: ": " + field}
+			}
+
+			switch name {
+			case "quality":
+				o.Quality = true
+			case "audio":
+				o.Audio = true
+			case "video":
+				o.Video = true
+			case "split":
+				o.Split = true
+			case "stream":
+				o.Stream = true
+			case "skip-cache-id":
+				o.SkipCache = true
+			case "fixing-video":
+				o.FixVideo = true
+			case "subs":
+				if !hasValue {
+					o.Subtitles = SubtitlesAuto
+					break
+				}
+				if !subtitlesLangs.MatchString(value) {
+					return o, &TaskError{Key: "The language of the subtitles is bad, example +subs=en,ru",
+						Hint: ": " + field}
+				}
+				o.Subtitles = value
+			default:
+				return o, &TaskError{Key: "Unknown flag", Hint: ": " + field}
+			}
+		case field == "-ss" || field == "-to":
+			if i+1 >= len(fields) {
+				return o, &TaskError{Key: "The time of the slice is bad, examples: 90, 1:30, 01:02:03, 1h2m3s",
+					Hint: ": " + field}
+			}
+			i++
+
+			d, err := ParseSliceTime(fields[i])
+			if err != nil {
+				return o, &TaskError{Key: "The time of the slice is bad, examples: 90, 1:30, 01:02:03, 1h2m3s",
+					Hint: ": " + field + " " + fields[i], Err: err}
+			}
+
+			if o.Slice == nil {
+				o.Slice = &TimeSlice{}
+			}
+			if field == "-ss" {
+				o.Slice.Start = d
+			} else {
+				o.Slice.End = d
+			}
+		case strings.HasPrefix(field, "format:"):
+			o.Format = strings.TrimPrefix(field, "format:")
+		case strings.HasPrefix(field, "playlist:"):
+			if choice := strings.TrimPrefix(field, "playlist:"); choice != "" {
+				o.Playlist = strings.Split(choice, ",")
+			}
+		case o.Url == "" && (strings.HasPrefix(field, "https://") || strings.HasPrefix(field, "http://") ||
+			strings.HasPrefix(field, "magnet:")):
+			o.Url = field
+		default:
+			o.Args = append(o.Args, field)
+		}
+	}
+
+	if o.Slice != nil && o.Slice.End > 0 && o.Slice.End <= o.Slice.Start {
+		return o, &TaskError{Key: "The end of the slice must be after the start"}
+	}
+
+	return o, nil
+}
+
+// Flags - the flags in the form of the message, without the url, the words and the choices of the pickers
+func (o Options) Flags() string {
+	var flags []string
+	for _, f := range []struct {
+		on   bool
+		flag string
+	}{
+		{o.Quality, "+quality"},
+		{o.Audio, "+audio"},
+		{o.Video, "+video"},
+		{o.Split, "+split"},
+		{o.Stream, "+stream"},
+		{o.SkipCache, "+skip-cache-id"},
+		{o.FixVideo, "+fixing-video"},
+	} {
+		if f.on {
+			flags = append(flags, f.flag)
+		}
+	}
+
+	switch o.Subtitles {
+	case "":
+	case SubtitlesAuto:
+		flags = append(flags, "+subs")
+	default:
+		flags = append(flags, "+subs="+o.Subtitles)
+	}
+	if o.Slice != nil {
+		flags = append(flags, o.Slice.Args()...)
+	}
+
+	return strings.Join(flags, " ")
+}
+
+// ParseSliceTime - seconds "90" or "90.5", "1:30", "01:02:03" or the duration "1h2m3s"
+func ParseSliceTime(value string) (time.Duration, error) {
+	if strings.ContainsAny(value, "hms") && !strings.Contains(value, ":") {
+		d, err := time.ParseDuration(value)
+		if err == nil && d < 0 {
+			err = fmt.Errorf("negative time %s", value)
+		}
+		return d, err
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("too many parts %s", value)
+	}
+
+	var d time.Duration
+	for i, part := range parts {
+		last := i == len(parts)-1
+
+		var (
+			num float64
+			err error
+		)
+		if last {
+			num, err = strconv.ParseFloat(part, 64)
+		} else {
+			var n int
+			n, err = strconv.Atoi(part)
+			num = float64(n)
+		}
+		if err != nil || num < 0 {
+			return 0, fmt.Errorf("bad part %q of %s", part, value)
+		}
+		// minutes and seconds after the bigger unit are less 60
+		if i > 0 && num >= 60 {
+			return 0, fmt.Errorf("bad part %q of %s", part, value)
+		}
+
+		d = d*60 + time.Duration(num*float64(time.Second))
+	}
+
+	return d, nil
+}
+
+// Args - the arguments of ffmpeg
+func (s TimeSlice) Args() []string {
+	var args []string
+	if s.Start > 0 {
+		args = append(args, "-ss", ffmpegTime(s.Start))
+	}
+	if s.End > 0 {
+		args = append(args, "-to", ffmpegTime(s.End))
+	}
+
+	return args
+}
+
+func ffmpegTime(d time.Duration) string {
+	text := fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	if ms := d.Milliseconds() % 1000; ms > 0 {
+		text += fmt.Sprintf(".%03d", ms)
+	}
+
+	return text
+}
+
+// pickerFlags - the flags of the message for the job of the picker, the choice goes before them
+func (t *Task) pickerFlags() string {
+	if flags := t.Options().Flags(); flags != "" {
+		return " " + flags
+	}
+
+	return ""
+}
+
+// optionsText - the caption of the torrent file has the flags till the files are chosen
+func (t *Task) optionsText() string {
+	if t.Message.Text == "" {
+		return t.Message.Caption
+	}
+
+	return t.Message.Text
+}
+
+// Options - the options of the message, the bad flags are checked by ValidateOptions before the job
+func (t *Task) Options() Options {
+	o, _ := ParseOptions(t.optionsText())
+
+	return o
+}
+
+func (t *Task) ValidateOptions() error {
+	_, err := ParseOptions(t.optionsText())
+
+	return err
+}
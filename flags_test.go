@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	text := "https://www.youtube.com/watch?v=abc&t=42 +quality +split -ss 1:30 -to 1h +subs=en,pt-BR format:137+ba"
+
+	o, err := ParseOptions(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Options{
+		Url:       "https://www.youtube.com/watch?v=abc&t=42",
+		Quality:   true,
+		Split:     true,
+		Subtitles: "en,pt-BR",
+		Slice:     &TimeSlice{Start: 90 * time.Second, End: time.Hour},
+		Format:    "137+ba",
+	}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("%s\n%+v\nwant %+v", text, o, want)
+	}
+
+	o, err = ParseOptions("zip:Season%201 +stream +subs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Url != "" || !o.Stream || o.Subtitles != SubtitlesAuto || !reflect.DeepEqual(o.Args, []string{"zip:Season%201"}) {
+		t.Errorf("torrent choice - %+v", o)
+	}
+}
+
+func TestParseOptionsErrors(t *testing.T) {
+	for text, key := range map[string]string{
+		"https://video.url +qualty":           "Unknown flag",
+		"https://video.url +split=1":          "Unknown flag",
+		"https://video.url -ss":               "The time of the slice is bad, examples: 90, 1:30, 01:02:03, 1h2m3s",
+		"https://video.url -ss 1:75":          "The time of the slice is bad, examples: 90, 1:30, 01:02:03, 1h2m3s",
+		"https://video.url -ss 2:00 -to 1:00": "The end of the slice must be after the start",
+		"https://video.url +subs=english!":    "The language of the subtitles is bad, example +subs=en,ru",
+	} {
+		_, err := ParseOptions(text)
+		if err == nil {
+			t.Errorf("%s - no error", text)
+			continue
+		}
+		if got := AsTaskError(err).Key; got != key {
+			t.Errorf("%s - %s, want %s", text, got, key)
+		}
+	}
+}
+
+func TestParseSliceTime(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"90":       90 * time.Second,
+		"90.5":     90*time.Second + 500*time.Millisecond,
+		"1:30":     90 * time.Second,
+		"01:02:03": time.Hour + 2*time.Minute + 3*time.Second,
+		"1h2m3s":   time.Hour + 2*time.Minute + 3*time.Second,
+		"45s":      45 * time.Second,
+	} {
+		got, err := ParseSliceTime(value)
+		if err != nil || got != want {
+			t.Errorf("%s - %s %v, want %s", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "1:2:3:4", "-5", "1:60", "-1m"} {
+		if _, err := ParseSliceTime(value); err == nil {
+			t.Errorf("%s - no error", value)
+		}
+	}
+}
+
+func TestTimeSliceArgs(t *testing.T) {
+	got := TimeSlice{Start: 90*time.Second + 250*time.Millisecond, End: time.Hour}.Args()
+	want := []string{"-ss", "00:01:30.250", "-to", "01:00:00"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%v, want %v", got, want)
+	}
+
+	if got := (TimeSlice{End: time.Minute}).Args(); !reflect.DeepEqual(got, []string{"-to", "00:01:00"}) {
+		t.Errorf("only the end - %v", got)
+	}
+}
+
+func TestOptionsFlags(t *testing.T) {
+	for text, want := range map[string]string{
+		"https://youtu.be/abc":                                      "",
+		"https://youtu.be/abc +split +quality format:137+ba":        "+quality +split",
+		"magnet:?xt=urn:btih:abc +stream +subs":                     "+stream +subs",
+		"https://youtu.be/abc -to 2:30 +subs=en,ru -ss 1:00.5 word": "+subs=en,ru -ss 00:01:00.500 -to 00:02:30",
+		"https://youtu.be/abc playlist:a,b +audio +skip-cache-id":   "+audio +skip-cache-id",
+	} {
+		o, err := ParseOptions(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := o.Flags()
+		if got != want {
+			t.Errorf("%s - %q, want %q", text, got, want)
+		}
+
+		// the flags of the picker job are parsed back to the same options
+		back, err := ParseOptions(o.Url + " " + got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.Format, o.Playlist, o.Args = "", nil, nil
+		if !reflect.DeepEqual(back, o) {
+			t.Errorf("%s - parsed back %+v, want %+v", text, back, o)
+		}
+	}
+}
+
+func TestParseChoices(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		format   string
+		playlist []string
+		zip      string
+		isZip    bool
+	}{
+		{text: "https://youtu.be/abc " + FormatChoice("137+ba") + " +split", format: "137+ba"},
+		{text: "https://youtu.be/abc +quality"},
+		{text: "https://www.youtube.com/playlist?list=PL1 " + PlaylistChoice([]string{"abc", "d-e_f"}) + " +quality",
+			playlist: []string{"abc", "d-e_f"}},
+		{text: "https://www.youtube.com/playlist?list=PL1 playlist: +quality"},
+		{text: TorrentZipChoice("") + " +split", isZip: true},
+		{text: TorrentZipChoice("Season 1") + " +split", zip: "Season 1", isZip: true},
+		{text: TorrentZipChoice("Show/Season 2/extras"), zip: "Show/Season 2/extras", isZip: true},
+		{text: "0,4,5 +split"},
+	} {
+		format, isFormat := ParseFormatChoice(c.text)
+		if format != c.format || isFormat != (c.format != "") {
+			t.Errorf("%s - format %q, ok %v", c.text, format, isFormat)
+		}
+		playlist, isPlaylist := ParsePlaylistChoice(c.text)
+		if !reflect.DeepEqual(playlist, c.playlist) || isPlaylist != (c.playlist != nil) {
+			t.Errorf("%s - playlist %v, ok %v", c.text, playlist, isPlaylist)
+		}
+		zip, isZip := ParseTorrentZip(c.text)
+		if zip != c.zip || isZip != c.isZip {
+			t.Errorf("%s - zip %q, ok %v", c.text, zip, isZip)
+		}
+	}
+}
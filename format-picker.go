@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// formatPickerMax - options in the keyboard, the lowest ones are dropped
+	formatPickerMax = 12
+	// VideoQualityAuto - the user chose the best format without the picker
+	VideoQualityAuto = -1
+	// FormatAuto - the choice of the default format of the bot
+	FormatAuto = "auto"
+)
+
+// FormatOption - the resolution and the codec of the video, the selector goes to yt-dlp -f
+type FormatOption struct {
+	Selector string
+	Height   int
+	Codec    string
+	// Size - the video with the audio, 0 if yt-dlp doesn't know it
+	Size int64
+}
+
+// FormatPicker - inline keyboard with the formats of the video, the chosen format goes to the job
+type FormatPicker struct {
+	PickerBase
+	Title   string
+	Options []FormatOption
+
+	remember bool
+	// the done choice, quality is remembered in the settings
+	choice  string
+	label   string
+	quality int
+}
+
+// AskFormat - the youtube video without the chosen format, the user has no default quality
+func (t *Task) AskFormat() bool {
+	if t.Source == nil || t.Source.Name != "video-url" || t.Settings.VideoQuality != 0 {
+		return false
+	}
+
+	o := t.Options()
+	u, err := url.Parse(o.Url)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(u.Host, "www."), "m.")
+	if host != "youtube.com" && host != "youtu.be" {
+		return false
+	}
+
+	if o.Format != "" || o.Playlist != nil || o.Slice != nil || o.Quality {
+		return false
+	}
+
+	return !t.AudioOnly()
+}
+
+// FormatChoice - the selector of the chosen format for the job, "format:137+ba"
+func FormatChoice(selector string) string {
+	return "format:" + selector
+}
+
+// ParseFormatChoice - the selector of the chosen format in the text of the job
+func ParseFormatChoice(text string) (string, bool) {
+	o, _ := ParseOptions(text)
+
+	return o.Format, o.Format != ""
+}
+
+// QualitySelector - the default quality of the user, the best video not higher the height
+func QualitySelector(height int) string {
+	return fmt.Sprintf("bv*[height<=%d]+ba/b[height<=%d]", height, height)
+}
+
+// FormatOptions - the best format of every resolution and codec, the video only formats are joined
+// with the best audio, the formats bigger 2 GB are dropped
+func FormatOptions(info InfoYtDlp) []FormatOption {
+	size := func(filesize int, approx int, tbr float64) int64 {
+		switch {
+		case filesize > 0:
+			return int64(filesize)
+		case approx > 0:
+			return int64(approx)
+		case tbr > 0 && info.Duration > 0:
+			return int64(tbr * 1000 / 8 * info.Duration)
+		}
+
+		return 0
+	}
+
+	var audioSize int64
+	for _, f := range info.Formats {
+		if f.Vcodec == "none" && f.Acodec != "none" && f.Acodec != "" {
+			if s := size(f.Filesize, f.FilesizeApprox, f.Tbr); s > audioSize {
+				audioSize = s
+			}
+		}
+	}
+
+	best := map[string]FormatOption{}
+	for _, f := range info.Formats {
+		if f.Vcodec == "none" || f.Vcodec == "" || f.Height == 0 || f.FormatID == "" {
+			continue
+		}
+
+		option := FormatOption{
+			Selector: f.FormatID,
+			Height:   f.Height,
+			Codec:    strings.Split(f.Vcodec, ".")[0],
+			Size:     size(f.Filesize, f.FilesizeApprox, f.Tbr),
+		}
+		// the video only format is downloaded with the best audio
+		if f.Acodec == "none" || f.Acodec == "" {
+			option.Selector += "+ba"
+			if option.Size > 0 {
+				option.Size += audioSize
+			}
+		}
+		if option.Size > telegramMaxFileSize {
+			continue
+		}
+
+		key := fmt.Sprintf("%d-%s", option.Height, option.Codec)
+		if prev, ok := best[key]; !ok || option.Size > prev.Size {
+			best[key] = option
+		}
+	}
+
+	var options []FormatOption
+	for _, val := range best {
+		options = append(options, val)
+	}
+	sort.Slice(options, func(i, j int) bool {
+		if options[i].Height != options[j].Height {
+			return options[i].Height > options[j].Height
+		}
+		return options[i].Codec < options[j].Codec
+	})
+	if len(options) > formatPickerMax {
+		options = options[:formatPickerMax]
+	}
+
+	return options
+}
+
+// OpenFormatPicker gets the formats of the video and sends the picker, the job starts when one is chosen
+func (t *Task) OpenFormatPicker() {
+	link, flags := t.Options().Url, t.pickerFlags()
+
+	ctx, cancel := context.WithTimeout(t.Ctx, 30*time.Second)
+	out, err := exec.CommandContext(ctx, "yt-dlp", "-j", "--no-playlist", "--socket-timeout", "10", link).Output()
+	cancel()
+
+	var info InfoYtDlp
+	if err == nil {
+		err = json.Unmarshal(out, &info)
+	}
+	options := FormatOptions(info)
+	// the formats are unknown, the video is downloaded as before
+	if err != nil || len(options) == 0 {
+		if err != nil {
+			log.Warn(err)
+		}
+		message := *t.Message
+		message.Text = link + " " + FormatChoice(FormatAuto) + flags
+		t.App.Queue <- QueueMessages{Message: &message}
+		return
+	}
+
+	t.OpenPicker(&FormatPicker{
+		PickerBase: PickerBase{Url: link, Flags: flags},
+		Title:      info.FullTitle,
+		Options:    options,
+	})
+}
+
+func (p *FormatPicker) Render() (string, tgbotapi.InlineKeyboardMarkup) {
+	tr := p.Translate
+
+	text := "📍 " + tr.Lang("Choose the quality of the video, max size 2 GB") + "\n\n▶️ " + p.Title
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, o := range p.Options {
+		size := "?"
+		if o.Size > 0 {
+			size = humanize.Bytes(uint64(o.Size))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("🎞 %dp · %s ~ %s", o.Height, o.Codec, size), fmt.Sprintf("fp:%d:f:%d", p.ID, i))))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⭐️ "+tr.Lang("Auto"), fmt.Sprintf("fp:%d:a", p.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("🎵 "+tr.Lang("Audio only"), fmt.Sprintf("fp:%d:m", p.ID))))
+
+	remember := "☑️"
+	if p.remember {
+		remember = "✅"
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(remember+" "+tr.Lang("Remember the quality"),
+			fmt.Sprintf("fp:%d:r", p.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("❌ "+tr.Lang("Cancel"), fmt.Sprintf("fp:%d:x", p.ID))))
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// Press - the button of the picker, returns the answer for the user and whether the format is chosen
+func (p *FormatPicker) Press(op string, arg string) (string, bool) {
+	tr := p.Translate
+
+	switch op {
+	case "r":
+		p.remember = !p.remember
+		return "", false
+	case "f":
+		num, err := strconv.Atoi(arg)
+		if err != nil || num < 0 || num >= len(p.Options) {
+			return "", false
+		}
+		o := p.Options[num]
+		p.choice = FormatChoice(o.Selector)
+		p.label = fmt.Sprintf("%dp · %s", o.Height, o.Codec)
+		p.quality = o.Height
+	case "a":
+		p.choice = FormatChoice(FormatAuto)
+		p.label = tr.Lang("Auto")
+		p.quality = VideoQualityAuto
+	case "m":
+		p.choice = "+audio"
+		p.label = tr.Lang("Audio only")
+	default:
+		return "", false
+	}
+
+	return "", true
+}
+
+// Choose - the format goes to the job, the quality is saved to the settings if it is asked
+func (p *FormatPicker) Choose() PickerChoice {
+	c := PickerChoice{Text: fmt.Sprintf("📥 %s\n%s", p.Title, p.label), Choice: p.choice}
+
+	if p.remember && p.quality != 0 {
+		s := LoadSettings(p.Message.From.ID)
+		s.VideoQuality = p.quality
+		if err := s.Save(); err != nil {
+			log.Error(err)
+		}
+		c.Answer = p.Translate.Lang("The quality is remembered")
+	}
+
+	return c
+}
+
+func (p *FormatPicker) Close(*App) {}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestFormatOptions(t *testing.T) {
+	var info InfoYtDlp
+	err := json.Unmarshal([]byte(`{"duration": 100, "formats": [
+		{"format_id": "140", "vcodec": "none", "acodec": "mp4a.40.2", "filesize": 2000000},
+		{"format_id": "137", "vcodec": "avc1.640028", "acodec": "none", "height": 1080, "filesize": 100000000},
+		{"format_id": "248", "vcodec": "vp9", "acodec": "none", "height": 1080, "filesize_approx": 80000000},
+		{"format_id": "136", "vcodec": "avc1.4d401f", "acodec": "none", "height": 720, "tbr": 4000},
+		{"format_id": "22", "vcodec": "avc1.64001F", "acodec": "mp4a.40.2", "height": 720, "filesize": 40000000},
+		{"format_id": "400", "vcodec": "av01.0.12M.08", "acodec": "none", "height": 2160, "filesize": 3000000000}
+	]}`), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := FormatOptions(info)
+	want := []FormatOption{
+		{Selector: "137+ba", Height: 1080, Codec: "avc1", Size: 102e6},
+		{Selector: "248+ba", Height: 1080, Codec: "vp9", Size: 82e6},
+		{Selector: "136+ba", Height: 720, Codec: "avc1", Size: 52e6},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("options %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("option %d - %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFormatOptionsEdges(t *testing.T) {
+	if got := FormatOptions(InfoYtDlp{}); len(got) != 0 {
+		t.Errorf("no formats - %v", got)
+	}
+
+	var info InfoYtDlp
+	err := json.Unmarshal([]byte(`{"formats": [
+		{"format_id": "140", "vcodec": "none", "acodec": "mp4a.40.2"},
+		{"format_id": "sb0", "vcodec": "none", "acodec": "none", "height": 90},
+		{"format_id": "136", "vcodec": "avc1.4d401f", "acodec": "none", "height": 720, "tbr": 4000},
+		{"format_id": "401", "vcodec": "av01.0.13M.08", "acodec": "none", "height": 2160, "filesize": 2500000000}
+	]}`), &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the size is unknown without the duration, the audio and the storyboards aren't videos
+	got := FormatOptions(info)
+	want := []FormatOption{{Selector: "136+ba", Height: 720, Codec: "avc1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%v, want %v", got, want)
+	}
+
+	var formats []map[string]any
+	for i := 0; i < formatPickerMax+3; i++ {
+		formats = append(formats, map[string]any{"format_id": strconv.Itoa(i), "vcodec": "avc1", "acodec": "mp4a",
+			"height": 100 + i, "filesize": 1000})
+	}
+	data, _ := json.Marshal(map[string]any{"formats": formats})
+	info = InfoYtDlp{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	got = FormatOptions(info)
+	if len(got) != formatPickerMax || got[0].Height != 100+formatPickerMax+2 || got[len(got)-1].Height != 103 {
+		t.Errorf("the lowest formats aren't dropped - %v", got)
+	}
+}
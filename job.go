@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"github.com/anacrolix/torrent"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued      = "queued"
+	JobDownloading = "downloading"
+	JobConverting  = "converting"
+	JobUploading   = "uploading"
+	JobDone        = "done"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+)
+
+const JobMaxAttempts = 3
+
+type Job struct {
+	ID            int64     `db:"id"`
+	ChatID        int64     `db:"chat_id"`
+	MessageID     int       `db:"message_id"`
+	TelegramID    int64     `db:"telegram_id"`
+	LanguageCode  string    `db:"language_code"`
+	SourceType    string    `db:"source_type"`
+	Url           string    `db:"url"`
+	TorrentFileID string    `db:"torrent_file_id"`
+	TorrentChoice string    `db:"torrent_choice"`
+	Flags         string    `db:"flags"`
+	State         string    `db:"state"`
+	Attempts      int       `db:"attempts"`
+	FailReason    string    `db:"fail_reason"`
+	FailDetail    string    `db:"fail_detail"`
+	Position      int       `db:"position"`
+	DateCreate    time.Time `db:"date_create"`
+	DateUpdate    time.Time `db:"date_update"`
+
+	// mu - the fields are changed by the task and by the buttons at the same time
+	mu sync.Mutex
+}
+
+func NewJob(message *tgbotapi.Message, sourceType string, torrentProcess *torrent.Torrent) *Job {
+	job := &Job{
+		ChatID:       message.Chat.ID,
+		MessageID:    message.MessageID,
+		TelegramID:   message.From.ID,
+		LanguageCode: message.From.LanguageCode,
+		SourceType:   sourceType,
+		State:        JobQueued,
+	}
+
+	if torrentProcess != nil {
+		job.Url = "magnet:?xt=urn:btih:" + torrentProcess.InfoHash().HexString()
+		job.TorrentChoice, job.Flags, _ = strings.Cut(message.Text, " ")
+		if message.Document != nil {
+			job.TorrentFileID = message.Document.FileID
+		}
+	} else {
+		job.Url, job.Flags, _ = strings.Cut(message.Text, " ")
+	}
+
+	err := Postgres.Get(&job.ID, `INSERT INTO jobs (chat_id, message_id, telegram_id, language_code, source_type,
+                  url, torrent_file_id, torrent_choice, flags, state, date_create, date_update)
+				  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW()) RETURNING id`,
+		job.ChatID, job.MessageID, job.TelegramID, job.LanguageCode, job.SourceType,
+		job.Url, job.TorrentFileID, job.TorrentChoice, job.Flags, job.State)
+	if err != nil {
+		log.Error(err)
+	}
+
+	return job
+}
+
+// SetState - the step of the task, the list of the tasks and the buttons read it meanwhile
+func (j *Job) SetState(state string) {
+	j.mu.Lock()
+	j.State = state
+	j.mu.Unlock()
+	if j.ID == 0 {
+		return
+	}
+
+	_, err := Postgres.Exec(`UPDATE jobs SET state = $1, date_update = NOW() WHERE id = $2`, state, j.ID)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// AddFlag - the flag is set while the job is running, the resumed job keeps it
+func (j *Job) AddFlag(flag string) {
+	j.mu.Lock()
+	j.Flags = strings.TrimSpace(j.Flags + " " + flag)
+	flags := j.Flags
+	j.mu.Unlock()
+	if j.ID == 0 {
+		return
+	}
+
+	_, err := Postgres.Exec(`UPDATE jobs SET flags = $1, date_update = NOW() WHERE id = $2`, flags, j.ID)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// SetPosition - the next video of the playlist, the resumed job starts from it
+func (j *Job) SetPosition(position int) {
+	j.mu.Lock()
+	j.Position = position
+	j.mu.Unlock()
+	if j.ID == 0 {
+		return
+	}
+
+	_, err := Postgres.Exec(`UPDATE jobs SET position = $1, date_update = NOW() WHERE id = $2`, position, j.ID)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// StateText - the state of the job, it may be changed by the task meanwhile
+func (j *Job) StateText() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.State
+}
+
+// FlagsText - the flags of the job, they may be added by the buttons meanwhile
+func (j *Job) FlagsText() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.Flags
+}
+
+// Fail - the job is failed, reason is the message key for analytics, detail is the log of the error
+func (j *Job) Fail(reason string, detail string) {
+	j.mu.Lock()
+	j.State = JobFailed
+	j.FailReason = reason
+	j.FailDetail = detail
+	j.mu.Unlock()
+	if j.ID == 0 {
+		return
+	}
+
+	_, err := Postgres.Exec(`UPDATE jobs SET state = $1, fail_reason = $2, fail_detail = $3, date_update = NOW()
+            WHERE id = $4`, JobFailed, reason, detail, j.ID)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+func (j *Job) Attempt() {
+	j.mu.Lock()
+	j.Attempts++
+	attempts := j.Attempts
+	j.mu.Unlock()
+	if j.ID == 0 {
+		return
+	}
+
+	_, err := Postgres.Exec(`UPDATE jobs SET attempts = $1, date_update = NOW() WHERE id = $2`, attempts, j.ID)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// Message rebuilds the user message that created the job
+func (j *Job) Message() *tgbotapi.Message {
+	text := j.Url
+	if j.SourceType == "torrent" {
+		text = j.TorrentChoice
+	}
+	if flags := j.FlagsText(); flags != "" {
+		text += " " + flags
+	}
+
+	return &tgbotapi.Message{
+		MessageID: j.MessageID,
+		From:      &tgbotapi.User{ID: j.TelegramID, LanguageCode: j.LanguageCode},
+		Chat:      &tgbotapi.Chat{ID: j.ChatID},
+		Text:      text,
+	}
+}
+
+func JobsInFlight() ([]Job, error) {
+	var jobs []Job
+	err := Postgres.Select(&jobs, `SELECT * FROM jobs WHERE state NOT IN ($1, $2, $3) ORDER BY id`,
+		JobDone, JobFailed, JobCancelled)
+
+	return jobs, errors.Wrap(err, "get jobs in flight")
+}
+
+// ResumeJobs puts back to the queue the jobs interrupted by restart
+func (a *App) ResumeJobs() {
+	jobs, err := JobsInFlight()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		tr := &Translate{Code: job.LanguageCode}
+
+		if job.Attempts >= JobMaxAttempts {
+			job.Fail("The bot was restarted and your task failed, please send it again", "attempts exhausted")
+			a.notifyJob(job, "😔 "+tr.Lang("The bot was restarted and your task failed, please send it again")+
+				"\n\n"+job.Url)
+			continue
+		}
+
+		go func(job *Job) {
+			message := job.Message()
+
+			var torrentProcess *torrent.Torrent
+			if job.SourceType == "torrent" {
+				torrentProcess = a.resumeTorrent(job)
+				if torrentProcess == nil {
+					job.Fail("The bot was restarted and your task failed, please send it again", "torrent not resumed")
+					a.notifyJob(job, "😔 "+tr.Lang("The bot was restarted and your task failed, please send it again")+
+						"\n\n"+job.Url)
+					return
+				}
+			}
+
+			a.notifyJob(job, "🔄 "+tr.Lang("The bot was restarted, your task is resumed"))
+			a.SendLogToChannel(message.From, "mess", "job resumed - "+job.Url)
+
+			a.Queue <- QueueMessages{Message: message, Job: job, Torrent: torrentProcess}
+		}(job)
+	}
+}
+
+func (a *App) resumeTorrent(job *Job) *torrent.Torrent {
+	var (
+		torrentProcess *torrent.Torrent
+		err            error
+	)
+
+	if job.TorrentFileID != "" {
+		file, errFile := a.Bot.GetFile(tgbotapi.FileConfig{FileID: job.TorrentFileID})
+		if errFile != nil {
+			log.Warn(errFile)
+		} else if spec, errSpec := TorrentSpec("", config.TgPathLocal+"/"+config.BotToken+"/"+
+			file.FilePath); errSpec == nil {
+			torrentProcess, _, err = a.TorClient.AddTorrentSpec(spec)
+		}
+	}
+	if torrentProcess == nil {
+		var spec *torrent.TorrentSpec
+		if spec, err = TorrentSpec(job.Url, ""); err == nil {
+			torrentProcess, _, err = a.TorClient.AddTorrentSpec(spec)
+		}
+	}
+	if err != nil {
+		log.Warn(err)
+		return nil
+	}
+
+	ctxTimeLimit, cancel := context.WithTimeout(a.Ctx, time.Minute)
+	defer cancel()
+	select {
+	case <-torrentProcess.GotInfo():
+	case <-ctxTimeLimit.Done():
+		torrentProcess.Drop()
+		return nil
+	}
+	a.Seeder.Acquire(torrentProcess)
+
+	return torrentProcess
+}
+
+func (a *App) notifyJob(job *Job, text string) {
+	mess := tgbotapi.NewMessage(job.ChatID, text)
+	mess.ReplyToMessageID = job.MessageID
+	mess.AllowSendingWithoutReply = true
+	mess.DisableWebPagePreview = true
+	if _, err := a.Bot.Send(mess); err != nil {
+		log.Warn(err)
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"database/sql"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/krol44/telegram-bot-api"
 	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -21,10 +25,17 @@ func main() {
 
 	Postgres = PostgresConnect()
 
+	if len(os.Args) >= 2 && os.Args[1] == "migrate" {
+		os.Exit(MigrateCommand(os.Args[2:]))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
 	app := Run()
 	go app.ObserverQueue()
+	app.ResumeJobs()
 
-	for update := range app.BotUpdates {
+	for update := range app.Updates(ctx) {
 		if update.Message != nil {
 			// logs
 			app.Logs(update.Message)
@@ -43,7 +54,15 @@ func main() {
 				continue
 			}
 
-			app.Queue <- struct{ Message *tgbotapi.Message }{Message: update.Message}
+			app.Queue <- QueueMessages{Message: update.Message}
+		}
+
+		if update.CallbackQuery != nil {
+			if app.IsBlockUser(update.CallbackQuery.From.ID) {
+				continue
+			}
+
+			go app.CallbackQuery(update.CallbackQuery)
 		}
 
 		if update.InlineQuery != nil {
@@ -197,4 +216,7 @@ func main() {
 			}
 		}
 	}
+
+	stop()
+	os.Exit(app.Shutdown(config.ShutdownTimeout))
 }
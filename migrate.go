@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// migrateLockKey - pg_advisory_lock key, the instances of the bot migrate one by one
+const migrateLockKey = 7419230551
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationApplied struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	DateApply time.Time `db:"date_apply"`
+}
+
+// migrations must stay ordered by version, never edit one that is already released - add a new one
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "init",
+		// if not exists - databases created before migrations already have these tables
+		Up: `
+create table if not exists users
+(
+    telegram_id      bigint  		not null,
+    date_create      timestamp  	not null,
+    name			 text    		default '' not null,
+    premium		     int     		default 0 not null,
+  	sent_ad       	 int     		default 0 not null,
+  	block            int     		default 0 not null,
+	block_why 	     text 	    	default '' not null,
+	language_code    varchar(10)	default 'en'
+);
+create unique index if not exists users_telegram_id_uindex
+    on users (telegram_id);
+
+create table if not exists logs
+(
+	id      serial
+        	constraint logs_pk
+            primary key,
+    json             text 		default '' not null,
+    date_create 	 timestamp 	not null
+);
+
+create table if not exists cache
+(
+	id      serial
+        	constraint cache_pk
+            primary key,
+    caption				text 		default '' not null,
+    native_path_file	text 		default '' not null,
+    native_md5_sum		text 		default '' not null,
+    video_url_id		text 		default '' not null,
+    tg_from_id			text 		default '' not null,
+    tg_file_id			text 		default '' not null,
+    tg_file_size		int			default 0  not null,
+    date_create			timestamp	not null
+);
+create index if not exists cache_native_path_file_index
+    on cache (native_path_file);
+create index if not exists cache_native_md5_sum_index
+    on cache (native_md5_sum);
+create index if not exists cache_video_url_id_index
+    on cache (video_url_id);
+
+create table if not exists links
+(
+	id      serial
+        	constraint links_pk
+            primary key,
+    md5_url				text 	default '' not null,
+    url					text 	default '' not null,
+    telegram_id			bigint	not null,
+    date_create			text 	default '' not null
+);
+create index if not exists links_md5_url
+    on links (md5_url);
+
+create table if not exists limits
+(
+	id      serial
+        	constraint limits_pk
+            primary key,
+    type_object			text 		default '' not null,
+    telegram_id			bigint		not null,
+    date_create			timestamp 	not null
+);
+create index if not exists limits_group
+    on limits (type_object, telegram_id, date_create);
+`,
+		Down: `
+drop table if exists limits;
+drop table if exists links;
+drop table if exists cache;
+drop table if exists logs;
+drop table if exists users;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "jobs",
+		Up: `
+create table jobs
+(
+	id      serial
+        	constraint jobs_pk
+            primary key,
+    chat_id				bigint		not null,
+    message_id			int			not null,
+    telegram_id			bigint		not null,
+    language_code		varchar(10)	default 'en' not null,
+    source_type			text		default '' not null,
+    url					text		default '' not null,
+    torrent_file_id		text		default '' not null,
+    torrent_choice		text		default '' not null,
+    flags				text		default '' not null,
+    state				text		default 'queued' not null,
+    attempts			int			default 0 not null,
+    date_create			timestamp	not null,
+    date_update			timestamp	not null
+);
+create index jobs_state_index
+    on jobs (state);
+create index jobs_chat_id_index
+    on jobs (chat_id);
+`,
+		Down: `
+drop table if exists jobs;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "jobs fail reason",
+		Up: `
+alter table jobs
+    add column fail_reason	text	default '' not null,
+    add column fail_detail	text	default '' not null;
+create index jobs_fail_reason_index
+    on jobs (fail_reason);
+`,
+		Down: `
+drop index if exists jobs_fail_reason_index;
+alter table jobs
+    drop column if exists fail_reason,
+    drop column if exists fail_detail;
+`,
+	},
+	{
+		Version: 4,
+		Name:    "cache variant",
+		Up: `
+alter table cache
+    add column variant	text	default '' not null;
+`,
+		Down: `
+alter table cache
+    drop column if exists variant;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "cache torrent file",
+		Up: `
+alter table cache
+    add column info_hash	text	default '' not null,
+    add column file_index	integer	default -1 not null;
+
+create index cache_info_hash_file_index_index
+    on cache (info_hash, file_index);
+`,
+		Down: `
+drop index if exists cache_info_hash_file_index_index;
+
+alter table cache
+    drop column if exists info_hash,
+    drop column if exists file_index;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "user settings",
+		Up: `
+create table if not exists user_settings
+(
+    telegram_id      bigint  		not null
+        	constraint user_settings_pk
+            primary key,
+    video_quality    integer 		default 0 not null,
+    audio_only       boolean 		default false not null,
+    language         varchar(10)	default '' not null,
+    caption          varchar(10)	default 'full' not null,
+    protect          boolean 		default false not null,
+    notify           boolean 		default true not null,
+    date_update      timestamp  	default now() not null
+);
+`,
+		Down: `
+drop table if exists user_settings;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "jobs position",
+		Up: `
+alter table jobs
+    add column position	integer	default 0 not null;
+`,
+		Down: `
+alter table jobs
+    drop column if exists position;
+`,
+	},
+}
+
+// MigrateLocked runs fn under the advisory lock, the lock is held by the own connection till fn is done
+func MigrateLocked(fn func() error) error {
+	ctx := context.Background()
+	conn, err := Postgres.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "migration lock connection")
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrateLockKey); err != nil {
+		return errors.Wrap(err, "migration lock")
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrateLockKey); err != nil {
+			log.Warn(errors.Wrap(err, "migration unlock"))
+		}
+	}()
+
+	return fn()
+}
+
+func MigrationLatest() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+
+	return migrations[len(migrations)-1].Version
+}
+
+func MigrateInitTable() error {
+	_, err := Postgres.Exec(`
+create table if not exists schema_migrations
+(
+    version      int        not null
+        constraint schema_migrations_pk
+            primary key,
+    name         text       default '' not null,
+    date_apply   timestamp  not null
+);`)
+
+	return errors.Wrap(err, "create schema_migrations")
+}
+
+func MigrateVersion() (int, error) {
+	var version struct {
+		Version int `db:"version"`
+	}
+	err := Postgres.Get(&version, `SELECT coalesce(max(version), 0) AS version FROM schema_migrations`)
+	if err != nil {
+		return 0, errors.Wrap(err, "get schema version")
+	}
+
+	return version.Version, nil
+}
+
+func MigrateUp() error {
+	current, err := MigrateVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		tx, err := Postgres.Beginx()
+		if err != nil {
+			return errors.Wrap(err, "begin migration")
+		}
+		if _, err := tx.Exec(m.Up); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "migration %04d_%s up", m.Version, m.Name)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, date_apply) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, time.Now()); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "migration %04d_%s save version", m.Version, m.Name)
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "migration %04d_%s commit", m.Version, m.Name)
+		}
+
+		log.Infof("Migration %04d_%s applied", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+func MigrateDown(steps int) error {
+	for i := 0; i < steps; i++ {
+		current, err := MigrateVersion()
+		if err != nil {
+			return err
+		}
+		if current == 0 {
+			log.Info("Nothing to roll back")
+			return nil
+		}
+
+		var m *Migration
+		for k := range migrations {
+			if migrations[k].Version == current {
+				m = &migrations[k]
+			}
+		}
+		if m == nil {
+			return errors.Errorf("migration %04d is unknown to this binary", current)
+		}
+
+		tx, err := Postgres.Beginx()
+		if err != nil {
+			return errors.Wrap(err, "begin migration")
+		}
+		if _, err := tx.Exec(m.Down); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "migration %04d_%s down", m.Version, m.Name)
+		}
+		if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "migration %04d_%s delete version", m.Version, m.Name)
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "migration %04d_%s commit", m.Version, m.Name)
+		}
+
+		log.Infof("Migration %04d_%s rolled back", m.Version, m.Name)
+	}
+
+	return nil
+}
+
+func MigrateStatus() error {
+	var applied []MigrationApplied
+	err := Postgres.Select(&applied, `SELECT version, name, date_apply FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return errors.Wrap(err, "get applied migrations")
+	}
+
+	appliedMap := map[int]MigrationApplied{}
+	for _, val := range applied {
+		appliedMap[val.Version] = val
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, m := range migrations {
+		status := "pending"
+		if val, ok := appliedMap[m.Version]; ok {
+			status = "applied " + val.DateApply.Format(time.DateTime)
+			delete(appliedMap, m.Version)
+		}
+		_, _ = fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, status)
+	}
+	for _, val := range appliedMap {
+		_, _ = fmt.Fprintf(w, "%04d\t%s\t%s\n", val.Version, val.Name, "unknown to this binary")
+	}
+
+	return w.Flush()
+}
+
+// MigrateOnStart applies pending migrations before the updates are taken, a schema newer than the binary is fatal.
+// Another instance waits on the lock and finds the schema up to date
+func MigrateOnStart() {
+	err := MigrateLocked(func() error {
+		if err := MigrateInitTable(); err != nil {
+			return err
+		}
+
+		current, err := MigrateVersion()
+		if err != nil {
+			return err
+		}
+		if current > MigrationLatest() {
+			return errors.Errorf("database schema version %d is newer than supported %d, update the bot",
+				current, MigrationLatest())
+		}
+
+		return MigrateUp()
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// MigrateCommand - tor-purr-bot migrate up|down [steps]|status
+func MigrateCommand(args []string) int {
+	if err := MigrateInitTable(); err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+
+	var err error
+	switch args[0] {
+	case "up":
+		err = MigrateLocked(MigrateUp)
+	case "down":
+		steps := 1
+		if len(args) >= 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Errorf("bad steps - %s", args[1])
+				return 2
+			}
+		}
+		err = MigrateLocked(func() error {
+			return MigrateDown(steps)
+		})
+	case "status":
+		err = MigrateStatus()
+	default:
+		log.Errorf("unknown migrate command - %s, use: migrate up | down [steps] | status", args[0])
+		return 2
+	}
+
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	return 0
+}
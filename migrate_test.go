@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrationsOrder(t *testing.T) {
+	prev := 0
+	for _, m := range migrations {
+		if m.Version != prev+1 {
+			t.Errorf("migration %s - version %d, want %d", m.Name, m.Version, prev+1)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %04d_%s - empty up or down", m.Version, m.Name)
+		}
+		prev = m.Version
+	}
+
+	if MigrationLatest() != prev {
+		t.Errorf("latest %d, want %d", MigrationLatest(), prev)
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	directUrlMaxSize    = 1999e6
+	directUrlSegments   = 4
+	directUrlMinSegment = 8 << 20
+	directUrlAttempts   = 5
+)
+
+var (
+	errFileTooBig   = errors.New("file is bigger 2 GB")
+	errPrivateAddr  = errors.New("address is not public")
+	directUrlClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+				Control:   publicDialControl,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.Errorf("redirect to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	// carrier-grade nat, it isn't in netip.Addr.IsPrivate
+	sharedAddrSpace = netip.MustParsePrefix("100.64.0.0/10")
+)
+
+// publicDialControl - the links of the users are fetched only from public addresses, the address is checked
+// after the resolving, so the redirects and the rebinding of the dns don't reach the local network
+func publicDialControl(_ string, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !isPublicAddr(addrPort.Addr()) {
+		return errors.Wrap(errPrivateAddr, address)
+	}
+
+	return nil
+}
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddrSpace.Contains(addr)
+}
+
+type directUrlInfo struct {
+	Name        string
+	ContentType string
+	// Length -1 if the server doesn't tell it
+	Length int64
+	Ranges bool
+}
+
+// directSegment - part of the file [start, end), end -1 - till the end of the body
+type directSegment struct {
+	start int64
+	end   int64
+	done  atomic.Int64
+}
+
+func (o *ObjectDirectUrl) Download() error {
+	urlFile := o.Task.Options().Url
+	if _, err := url.ParseRequestURI(urlFile); err != nil {
+		return &TaskError{Key: "File url is bad", Err: err}
+	}
+	o.Task.DescriptionUrl = urlFile
+
+	info, err := o.head(urlFile)
+	if o.Task.Stopped() {
+		return o.Task.StopError()
+	}
+	if err != nil {
+		return &TaskError{Key: "File url is bad", Retryable: true, Detail: urlFile, Err: err}
+	}
+	// a web page, not a file
+	if strings.HasPrefix(info.ContentType, "text/html") {
+		return &TaskError{Key: "Not allowed url, I support only", Hint: ":\n" + SupportedHosts(), Detail: urlFile}
+	}
+	if info.Length > directUrlMaxSize {
+		return &TaskError{Key: "File is bigger 2 GB", Detail: urlFile}
+	}
+
+	if err := o.Task.Limit(); err != nil {
+		return err
+	}
+
+	if err := o.Task.Alloc(); err != nil {
+		return err
+	}
+
+	if err := o.Task.Reserve(info.Length, nil); err != nil {
+		return err
+	}
+
+	folder := o.Task.Workspace + "/" + o.Task.UniqueId("files-direct")
+	if err := os.Mkdir(folder, os.ModePerm); err != nil {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Err: err}
+	}
+	filePath := folder + "/" + info.Name
+
+	ctx, cancel := context.WithTimeout(o.Task.Ctx, 30*time.Minute)
+	defer cancel()
+
+	segments := directSegments(info.Length, info.Ranges)
+
+	// progress and protection, the download is killed if nothing comes for a minute
+	var stalled atomic.Bool
+	go func() {
+		var (
+			last       int64
+			lastGrowth = time.Now()
+		)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(2 * time.Second):
+			}
+
+			var done int64
+			for _, seg := range segments {
+				done += seg.done.Load()
+			}
+
+			if done != last {
+				lastGrowth = time.Now()
+			} else if time.Since(lastGrowth) > time.Minute {
+				log.Warning("kill download direct url")
+				stalled.Store(true)
+				cancel()
+				return
+			}
+
+			stat, percent := o.StatDl(info, done, done-last)
+			last = done
+
+			o.Task.SetProgress(percent)
+			if o.Task.MessageTextLast != stat {
+				o.Task.Send(o.Task.EditProgress(stat))
+				o.Task.MessageTextLast = stat
+			}
+		}
+	}()
+
+	err = o.fetch(ctx, urlFile, filePath, info, segments)
+	cancel()
+
+	if o.Task.Stopped() {
+		return o.Task.StopError()
+	}
+	if errors.Is(err, errFileTooBig) {
+		return &TaskError{Key: "File is bigger 2 GB", Detail: urlFile}
+	}
+	if stalled.Load() || errors.Is(err, context.DeadlineExceeded) {
+		return &TaskError{Key: "Didn't have time to download, maximum 30 minutes or speed is low",
+			Retryable: true, Detail: urlFile}
+	}
+	if err != nil {
+		return &TaskError{Key: "File url is bad", Retryable: true, Detail: urlFile, Err: err}
+	}
+
+	o.Task.Send(o.Task.EditProgress("✅ " + o.Task.Lang("Download is finished, wait next step")))
+
+	if o.Task.IsAllowFormatForConvert(filePath) {
+		cache := Cache{Task: o.Task}
+		if cache.TrySendThroughMd5(filePath) {
+			return ErrSentFromCache
+		}
+	}
+
+	o.Task.File = filePath
+
+	return nil
+}
+
+func (o *ObjectDirectUrl) StatDl(info directUrlInfo, done int64, speed int64) (string, float64) {
+	var percentage float64
+	size := "?"
+	if info.Length > 0 {
+		percentage = float64(done) / float64(info.Length) * 100
+		size = humanize.Bytes(uint64(info.Length))
+	}
+
+	stat := fmt.Sprintf("📄 %s\n\n🔥 "+o.Task.Lang("Progress")+": \t%s / %s  %.2f%%\n\n🔽 "+
+		o.Task.Lang("Speed")+": %s/s", info.Name, humanize.Bytes(uint64(done)), size, percentage,
+		humanize.Bytes(uint64(speed/2)))
+
+	return stat, percentage
+}
+
+// head - name, type and size of the file, servers without HEAD are asked for the first byte
+func (o *ObjectDirectUrl) head(urlFile string) (directUrlInfo, error) {
+	ctx, cancel := context.WithTimeout(o.Task.Ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, urlFile, nil)
+	if err != nil {
+		return directUrlInfo{}, err
+	}
+	resp, err := directUrlClient.Do(req)
+	if err != nil {
+		return directUrlInfo{}, err
+	}
+	resp.Body.Close()
+
+	info := directUrlInfo{Length: resp.ContentLength}
+
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusForbidden {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, urlFile, nil)
+		if err != nil {
+			return directUrlInfo{}, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err = directUrlClient.Do(req)
+		if err != nil {
+			return directUrlInfo{}, err
+		}
+		resp.Body.Close()
+
+		info.Length = resp.ContentLength
+		if resp.StatusCode == http.StatusPartialContent {
+			// bytes 0-0/12345
+			_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+			info.Length, err = strconv.ParseInt(total, 10, 64)
+			if err != nil {
+				info.Length = -1
+			}
+			info.Ranges = info.Length > 0
+		}
+	} else {
+		info.Ranges = resp.Header.Get("Accept-Ranges") == "bytes" && info.Length > 0
+	}
+
+	if resp.StatusCode >= 400 {
+		return directUrlInfo{}, errors.Errorf("status %d", resp.StatusCode)
+	}
+
+	info.ContentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	info.Name = directUrlFileName(resp, info.ContentType)
+
+	return info, nil
+}
+
+func directUrlFileName(resp *http.Response, contentType string) string {
+	var name string
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		// the url after redirects
+		name, _ = url.PathUnescape(path.Base(resp.Request.URL.Path))
+	}
+
+	name = strings.NewReplacer("/", "", "\\", "", "#", "").Replace(path.Base(name))
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+
+	if path.Ext(name) == "" {
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+
+	return name
+}
+
+func directSegments(length int64, ranges bool) []*directSegment {
+	if !ranges || length <= 0 {
+		return []*directSegment{{start: 0, end: length}}
+	}
+
+	count := int64(directUrlSegments)
+	if need := (length + directUrlMinSegment - 1) / directUrlMinSegment; need < count {
+		count = need
+	}
+
+	var segments []*directSegment
+	size := length / count
+	for i := int64(0); i < count; i++ {
+		seg := &directSegment{start: i * size, end: (i + 1) * size}
+		if i == count-1 {
+			seg.end = length
+		}
+		segments = append(segments, seg)
+	}
+
+	return segments
+}
+
+func (o *ObjectDirectUrl) fetch(ctx context.Context, urlFile string, filePath string, info directUrlInfo,
+	segments []*directSegment) error {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(segments))
+	)
+	for i, seg := range segments {
+		wg.Add(1)
+		go func(i int, seg *directSegment) {
+			defer wg.Done()
+			if errs[i] = o.fetchSegment(ctx, urlFile, file, seg, info.Ranges); errs[i] != nil {
+				// the file is broken without the segment
+				cancel()
+			}
+		}(i, seg)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchSegment downloads the segment, after a failure it continues from the last byte
+func (o *ObjectDirectUrl) fetchSegment(ctx context.Context, urlFile string, file *os.File, seg *directSegment,
+	ranges bool) error {
+	for attempt := 1; ; attempt++ {
+		err := fetchRange(ctx, urlFile, file, seg, ranges)
+		if err == nil || ctx.Err() != nil || errors.Is(err, errFileTooBig) || attempt >= directUrlAttempts {
+			return err
+		}
+
+		log.Warnf("direct url segment %d-%d, attempt %d: %s", seg.start, seg.end, attempt, err)
+		if !ranges {
+			// without ranges only from the start
+			seg.done.Store(0)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 2 * time.Second):
+		}
+	}
+}
+
+func fetchRange(ctx context.Context, urlFile string, file *os.File, seg *directSegment, ranges bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlFile, nil)
+	if err != nil {
+		return err
+	}
+
+	from := seg.start + seg.done.Load()
+	if ranges {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, seg.end-1))
+	}
+
+	resp, err := directUrlClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if ranges && resp.StatusCode != http.StatusPartialContent {
+		return errors.Errorf("range is not served, status %d", resp.StatusCode)
+	}
+	if !ranges && resp.StatusCode != http.StatusOK {
+		return errors.Errorf("status %d", resp.StatusCode)
+	}
+
+	var body io.Reader = resp.Body
+	if seg.end > 0 {
+		body = io.LimitReader(resp.Body, seg.end-from)
+	}
+
+	_, err = io.Copy(&segmentWriter{file: file, offset: from, seg: seg}, body)
+	if err != nil {
+		return err
+	}
+
+	if seg.end > 0 && seg.start+seg.done.Load() < seg.end {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+type segmentWriter struct {
+	file   *os.File
+	offset int64
+	seg    *directSegment
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	if w.offset+int64(len(p)) > directUrlMaxSize {
+		return 0, errFileTooBig
+	}
+
+	n, err := w.file.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	w.seg.done.Add(int64(n))
+
+	return n, err
+}
+
+func (o *ObjectDirectUrl) Convert() error {
+	var c = Convert{Task: o.Task, IsTorrent: false}
+
+	if !c.Task.IsAllowFormatForConvert(c.Task.File) {
+		return nil
+	}
+
+	var err error
+	o.Task.FileConverted, err = c.Run()
+
+	return err
+}
+
+func (o *ObjectDirectUrl) Send() error {
+	switch path.Ext(o.Task.File) {
+	case ".flac", ".mp3", ".ogg", ".wav":
+		o.Task.Files = []string{o.Task.File}
+		return o.Task.SendAudio()
+	}
+
+	if path.Ext(o.Task.FileConverted.FilePath) == ".mp4" {
+		return o.Task.SendVideo(false)
+	}
+
+	return o.Task.SendDoc()
+}
+
+func (o *ObjectDirectUrl) Clean() {
+	o.Task.RemoveMessageEdit()
+}
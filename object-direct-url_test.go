@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDirectSegments(t *testing.T) {
+	segments := directSegments(100<<20+3, true)
+	if len(segments) != directUrlSegments {
+		t.Fatalf("segments %d, want %d", len(segments), directUrlSegments)
+	}
+
+	var next int64
+	for _, seg := range segments {
+		if seg.start != next {
+			t.Errorf("segment starts at %d, want %d", seg.start, next)
+		}
+		next = seg.end
+	}
+	if next != 100<<20+3 {
+		t.Errorf("segments end at %d", next)
+	}
+
+	if segments := directSegments(1<<20, true); len(segments) != 1 {
+		t.Errorf("small file is split into %d segments", len(segments))
+	}
+	if segments := directSegments(-1, false); len(segments) != 1 || segments[0].end != -1 {
+		t.Error("file without length is not a single open segment")
+	}
+}
+
+func TestDirectUrlFetch(t *testing.T) {
+	content := make([]byte, 20<<20+7)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	// the first request of a range is cut, the resumed one starts after the received bytes
+	var (
+		mu  sync.Mutex
+		cut = map[string]bool{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// bytes=from-to, the resumed request has the same end
+		from, end, isRange := strings.Cut(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "-")
+		mu.Lock()
+		first := isRange && !cut[end]
+		cut[end] = true
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Content-Length", "1000")
+			w.WriteHeader(http.StatusPartialContent)
+			start, _ := strconv.Atoi(from)
+			_, _ = w.Write(content[start : start+10])
+			return
+		}
+		http.ServeContent(w, r, "file.bin", time.Now(), bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// the test server is local, the client of the links refuses it
+	client := directUrlClient
+	directUrlClient = server.Client()
+	defer func() { directUrlClient = client }()
+
+	for _, ranges := range []bool{true, false} {
+		filePath := filepath.Join(t.TempDir(), "file.bin")
+		o := &ObjectDirectUrl{}
+
+		info := directUrlInfo{Name: "file.bin", Length: int64(len(content)), Ranges: ranges}
+		err := o.fetch(context.Background(), server.URL, filePath, info, directSegments(info.Length, ranges))
+		if err != nil {
+			t.Fatalf("ranges %v: %s", ranges, err)
+		}
+
+		got, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("ranges %v: file differs, size %d want %d", ranges, len(got), len(content))
+		}
+	}
+}
+
+func TestDirectUrlPublicOnly(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("%s - public %v, want %v", addr, got, want)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the local server is reached")
+	}))
+	defer server.Close()
+
+	_, err := directUrlClient.Get(server.URL)
+	if !errors.Is(err, errPrivateAddr) {
+		t.Errorf("error %v, want %v", err, errPrivateAddr)
+	}
+}
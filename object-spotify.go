@@ -1,44 +1,36 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	tgbotapi "github.com/krol44/telegram-bot-api"
 	log "github.com/sirupsen/logrus"
 	"net/url"
 	"os"
 	"os/exec"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-func (o *ObjectSpotify) Download() bool {
-	urlAudio := o.Task.Message.Text
-
-	sp := strings.Split(o.Task.Message.Text, "&")
-	if len(sp) >= 1 {
-		urlAudio = sp[0]
-	}
+func (o *ObjectSpotify) Download() error {
+	urlAudio := o.Task.Options().Url
 	o.Task.DescriptionUrl = urlAudio
 
 	_, err := url.ParseRequestURI(urlAudio)
 	if err != nil {
-		o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID, "❗️ "+o.Task.Lang("Audio url is bad")))
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Audio url is bad", Err: err}
 	}
 
-	if o.Task.Limit("spotify") {
-		log.Debug("limit exceeded")
-		return false
+	if err := o.Task.Limit(); err != nil {
+		return err
 	}
 
-	if !o.Task.Alloc("spotify") {
-		o.Task.App.SendLogToChannel(o.Task.Message.From, "mess", "❗️ return - error alloc")
-		return false
+	if err := o.Task.Alloc(); err != nil {
+		return err
 	}
 
-	folder := config.DirBot + "/storage" + "/" + o.Task.UniqueId("files-audio")
+	folder := o.Task.Workspace + "/" + o.Task.UniqueId("files-audio")
 
 	ffmpegPath := "./ffmpeg"
 	if config.IsDev {
@@ -52,8 +44,14 @@ func (o *ObjectSpotify) Download() bool {
 		"--output", fmt.Sprintf("%s/{track-number}. {artist} - {title} ({year}).{output-ext}", folder),
 	}
 
-	cmd := exec.Command("spotdl", args...)
+	ctx, cancel := context.WithCancel(o.Task.Ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "spotdl", args...)
 	defer func(c *exec.Cmd) {
+		if c.Process == nil {
+			return
+		}
 		c.Process.Kill()
 		if err := c.Wait(); err != nil {
 			log.Info(err)
@@ -61,36 +59,35 @@ func (o *ObjectSpotify) Download() bool {
 		c.Process.Release()
 	}(cmd)
 
-	stopProtected := false
-	go func(cmd *exec.Cmd, folder string, stopProtected *bool) {
+	// protected, kill the download if the folder doesn't grow for a minute
+	var stalled atomic.Bool
+	go func(folder string) {
 		var sizeSave int64
 		for {
-			time.Sleep(60 * time.Second)
-
-			if *stopProtected {
-				break
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(60 * time.Second):
 			}
-			size, _ := o.Task.DirSize(folder)
 
+			size, _ := o.Task.DirSize(folder)
 			if sizeSave == size {
 				log.Warning("kill cmd download audio url")
-				o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID,
-					"❗️ "+o.Task.Lang("Audio url is bad")+" 4"))
-				break
+				stalled.Store(true)
+				cancel()
+				return
 			}
 			sizeSave = size
 		}
-	}(cmd, folder, &stopProtected)
+	}(folder)
 
 	stdout, err := cmd.StdoutPipe()
 	cmd.Stderr = cmd.Stdout
 	if err != nil {
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Something wrong... I will be fixing it", Err: err}
 	}
 	if err = cmd.Start(); err != nil {
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "spotdl start", Err: err}
 	}
 
 	for {
@@ -116,22 +113,23 @@ func (o *ObjectSpotify) Download() bool {
 
 		mess := "🔥 " + line
 		if o.Task.MessageTextLast != mess {
-			o.Task.Send(tgbotapi.NewEditMessageText(o.Task.Message.Chat.ID, o.Task.MessageEditID, mess))
+			o.Task.Send(o.Task.EditProgress(mess))
 			o.Task.MessageTextLast = mess
 		}
 
-		if _, bo := o.Task.App.ChatsWork.StopTasks.Load(o.Task.Message.Chat.ID); bo {
-			stopProtected = true
-			return false
-		}
-
 		time.Sleep(time.Second)
 	}
 
+	if o.Task.Stopped() {
+		return o.Task.StopError()
+	}
+	if stalled.Load() {
+		return &TaskError{Key: "Audio url is bad", Retryable: true, Detail: "download stalled - " + urlAudio}
+	}
+
 	dir, err := os.ReadDir(folder)
 	if err != nil {
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Audio url is bad", Detail: urlAudio, Err: err}
 	}
 
 	var filesPath []string
@@ -140,18 +138,20 @@ func (o *ObjectSpotify) Download() bool {
 		filesPath = append(filesPath, path)
 	}
 
-	stopProtected = true
+	if len(filesPath) == 0 {
+		return &TaskError{Key: "Audio url is bad", Detail: "no files - " + urlAudio}
+	}
 
 	o.Task.Files = filesPath
 
-	return true
+	return nil
 }
 
-func (o *ObjectSpotify) Convert() bool {
-	return true
+func (o *ObjectSpotify) Convert() error {
+	return nil
 }
 
-func (o *ObjectSpotify) Send() bool {
+func (o *ObjectSpotify) Send() error {
 	return o.Task.SendAudio()
 }
 
@@ -4,176 +4,428 @@ import (
 	"context"
 	"fmt"
 	"github.com/anacrolix/torrent"
-	tgbotapi "github.com/krol44/telegram-bot-api"
 	log "github.com/sirupsen/logrus"
 	"path"
-	"strconv"
 	"strings"
 	"time"
 )
 
-func (o *ObjectTorrent) Download() bool {
-	if o.Task.Limit("torrent") {
-		log.Debug("limit exceeded")
-		return false
+func (o *ObjectTorrent) Download() error {
+	// the first file of the batch, the limit and the slot are taken once per job
+	if o.Files == nil {
+		if err := o.prepare(); err != nil {
+			return err
+		}
+
+		if err := o.Task.Alloc(); err != nil {
+			return err
+		}
+
+		// if name not correct, the name of the torrent used by another job is kept
+		if !o.Task.App.Seeder.Shared(o.Task.Torrent.Process) {
+			o.Task.Torrent.Process.SetDisplayName(o.Task.UniqueId("temp-torrent-name"))
+		}
+		o.Task.Torrent.Process.SetMaxEstablishedConns(200)
+
+		if err := o.Task.Reserve(o.expectedSize(), o.writtenSize()); err != nil {
+			return err
+		}
 	}
 
-	if !o.Task.AllocTorrent("torrent") {
-		o.Task.App.SendLogToChannel(o.Task.Message.From, "mess", "❗️ return - error alloc")
-		return false
+	if o.zip {
+		return o.downloadZip()
 	}
 
-	o.Task.Torrent.Process = o.TorrentProcess
+	// only the chosen file is downloaded at a time
+	fileChosen := o.Files[o.current]
+	o.raise(fileChosen)
 
-	var fileChosen *torrent.File
-	for _, val := range o.Task.Torrent.Process.Files() {
-		recoveryPath := val.Path() + " ~ " + strconv.FormatInt(val.Length()>>20, 10) + " MB"
-		if strings.Contains(recoveryPath, o.Task.Message.Text) {
-			if val.Length() > 1999e6 { // more 2 GB
-				o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID,
-					fmt.Sprintf("😔 "+o.Task.Lang("File is bigger 2 GB"))))
-				o.Task.App.SendLogToChannel(o.Task.Message.From, "mess", "File is bigger 2 GB")
+	o.Task.Torrent.Name = fileChosen.DisplayPath()
 
-				_, err := Postgres.Exec(`DELETE FROM limits WHERE id = any 
-                         (array(SELECT id FROM limits WHERE telegram_id = $1 AND type_object = $2
-                                                      ORDER BY date_create DESC LIMIT 1))`,
-					o.Task.Message.From.ID, "torrent")
-				if err != nil {
-					log.Error(err)
-				}
+	var batchStat string
+	if len(o.Files) > 1 {
+		batchStat = fmt.Sprintf("📦 %d / %d - %s\n\n", o.current+1, len(o.Files), fileChosen.DisplayPath())
+	}
 
-				return false
-			}
-			val.SetPriority(torrent.PiecePriorityNow)
-			fileChosen = val
-		} else {
-			val.SetPriority(torrent.PiecePriorityNone)
+	pathway := path.Clean(config.DirBot + "/torrent-client/" + fileChosen.Path())
+
+	cache := Cache{Task: o.Task}
+	if cache.TrySendTorrent(o.Task.Torrent.Process.InfoHash(),
+		TorrentFileIndex(o.Task.Torrent.Process, pathway), fileChosen.Path()) {
+		return ErrSentFromCache
+	}
+
+	if o.Task.StreamEnabled() && o.Task.IsAllowFormatForConvert(pathway) {
+		return o.openStream(fileChosen, pathway)
+	}
+
+	if err := o.await([]*torrent.File{fileChosen}, batchStat); err != nil {
+		return err
+	}
+
+	// the files sent before the cache by the info hash are found by the path and md5
+	if fileChosen.Length() > telegramMaxFileSize {
+		// the file was sent in parts, md5 of such a file isn't counted
+		if cache.TrySendParts(pathway) {
+			return ErrSentFromCache
 		}
+	} else {
+		if cache.TrySend("video", pathway) {
+			return ErrSentFromCache
+		}
+		if cache.TrySendThroughMd5(pathway) {
+			return ErrSentFromCache
+		}
+	}
+	if cache.TrySend("doc", o.Task.Torrent.Name+".torrent") {
+		return ErrSentFromCache
 	}
 
-	if fileChosen == nil {
-		log.Error("file chosen is empty")
-		return false
+	o.Task.File = pathway
+
+	return nil
+}
+
+// openStream - the video is converted while it is downloading, ffmpeg reads it from the local http server
+func (o *ObjectTorrent) openStream(fileChosen *torrent.File, pathway string) error {
+	cache := Cache{Task: o.Task}
+	if cache.TrySend("video", pathway) {
+		return ErrSentFromCache
 	}
 
-	// if name not correct
-	o.Task.Torrent.Process.SetDisplayName(o.Task.UniqueId("temp-torrent-name"))
+	o.Task.Torrent.Process.AllowDataDownload()
 
-	o.Task.Torrent.Process.SetMaxEstablishedConns(200)
+	ctx, cancel := context.WithCancel(o.Task.Ctx)
+	url, closeStream, err := o.Task.App.Stream.Open(ctx, fileChosen)
+	if err != nil {
+		cancel()
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "stream open", Err: err}
+	}
 
-	<-o.Task.Torrent.Process.GotInfo()
+	o.stream = url
+	o.stalled.Store(false)
+	o.closeStream = func() {
+		cancel()
+		closeStream()
+	}
 
-	o.Task.Torrent.Name = fileChosen.DisplayPath()
+	// ffmpeg gets the error of reading if the torrent doesn't give pieces for a minute
+	go func() {
+		var (
+			last       int64
+			lastGrowth = time.Now()
+		)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+
+			completed := fileChosen.BytesCompleted()
+			if completed == fileChosen.Length() {
+				return
+			}
+			if completed != last {
+				last, lastGrowth = completed, time.Now()
+			} else if time.Since(lastGrowth) > time.Minute {
+				log.Warning("kill stream torrent")
+				o.stalled.Store(true)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	o.Task.File = pathway
+
+	return nil
+}
+
+// downloadZip - all files of the folder, the archive is sent from the cache without the download
+func (o *ObjectTorrent) downloadZip() error {
+	o.Task.Torrent.Name = o.zipName()
 
-	ctx, cancelProgress := context.WithCancel(context.Background())
-	go func(ctx context.Context, fileChosen *torrent.File) {
+	cache := Cache{Task: o.Task}
+	if cache.TrySend("doc", o.zipKey()) || cache.TrySendParts(o.zipKey()) {
+		return ErrSentFromCache
+	}
+
+	o.raise(o.Files...)
+
+	return o.await(o.Files, fmt.Sprintf("🗜 %s - %d\n\n", o.Task.Torrent.Name, len(o.Files)))
+}
+
+// await - waiting for the files, the progress is shown for all of them
+func (o *ObjectTorrent) await(files []*torrent.File, label string) error {
+	ctx, cancel := context.WithTimeout(o.Task.Ctx, 30*time.Minute)
+	defer cancel()
+
+	go func() {
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			default:
-				if _, bo := o.Task.App.ChatsWork.StopTasks.Load(o.Task.Message.Chat.ID); bo {
-					return
-				}
+			case <-time.After(time.Second):
+			}
 
-				stat, percent := o.Task.StatDlTor(fileChosen)
-				if percent == 100 {
-					return
-				}
+			stat, percent := o.Task.StatDlTor(files...)
+			stat = label + stat
+			o.Task.SetProgress(percent)
+			if percent == 100 {
+				return
+			}
 
-				go func(ctx context.Context, t *Task) {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						if time.Now().Second()%2 == 0 && t.MessageTextLast != stat {
-							t.Send(tgbotapi.NewEditMessageText(t.Message.Chat.ID, t.MessageEditID, stat))
-							t.MessageTextLast = stat
-						}
+			go func(t *Task) {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					if time.Now().Second()%2 == 0 && t.MessageTextLast != stat {
+						t.Send(t.EditProgress(stat))
+						t.MessageTextLast = stat
 					}
-				}(ctx, o.Task)
-			}
-			time.Sleep(time.Second)
+				}
+			}(o.Task)
 		}
-	}(ctx, fileChosen)
+	}()
 
 	o.Task.Torrent.Process.AllowDataDownload()
 
-	timeStartToWork := time.Now().Unix()
-	maxTimeWork := int64(1800)
-	var sizeCheck int
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
+	var (
+		sizeCheck int
+		noSeeds   bool
+	)
+wait:
 	for {
-		if time.Now().Unix() > timeStartToWork+maxTimeWork {
-			break
-		}
-		if _, bo := o.Task.App.ChatsWork.StopTasks.Load(o.Task.Message.Chat.ID); bo {
-			break
+		var length, completed int64
+		for _, val := range files {
+			length += val.FileInfo().Length
+			completed += val.BytesCompleted()
 		}
-		if fileChosen.FileInfo().Length == fileChosen.BytesCompleted() {
+		if length == completed {
 			break
 		}
 
-		if sizeCheck > 60 && fileChosen.BytesCompleted() == 0 {
-			maxTimeWork = 0
+		if sizeCheck > 60 && completed == 0 {
+			noSeeds = true
 			break
 		}
 		sizeCheck++
 
-		time.Sleep(time.Second)
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
 	}
 
-	cancelProgress()
+	timeIsUp := noSeeds || ctx.Err() == context.DeadlineExceeded
+	cancel()
 
-	if time.Now().Unix() > timeStartToWork+maxTimeWork {
-		o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID,
-			fmt.Sprintf("😔 "+o.Task.Lang("Didn't have time to download, maximum 30 minutes or speed is low"))))
-		o.Task.App.SendLogToChannel(o.Task.Message.From, "mess",
-			"didn't have time to download torrent")
+	// the torrent may be shared with other jobs and seeded, the seeder drops it after the release
+	if timeIsUp {
+		return &TaskError{Key: "Didn't have time to download, maximum 30 minutes or speed is low",
+			Retryable: true, Detail: o.Task.Torrent.Name}
+	}
 
-		o.Task.Torrent.Process.Drop()
-		return false
+	if o.Task.Stopped() {
+		return o.Task.StopError()
 	}
 
-	if _, bo := o.Task.App.ChatsWork.StopTasks.Load(o.Task.Message.Chat.ID); bo {
-		o.Task.Torrent.Process.Drop()
-		return false
+	o.Task.Send(o.Task.EditProgress("✅ " + o.Task.Lang("Torrent downloaded, wait next step")))
+
+	return nil
+}
+
+// prepare - the limit and the files chosen in the picker
+func (o *ObjectTorrent) prepare() error {
+	if err := o.Task.Limit(); err != nil {
+		return err
 	}
 
-	o.Task.Send(tgbotapi.NewEditMessageText(o.Task.Message.Chat.ID, o.Task.MessageEditID,
-		"✅ "+o.Task.Lang("Torrent downloaded, wait next step")))
+	o.Task.Torrent.Process = o.TorrentProcess
 
-	pathway := path.Clean(config.DirBot + "/torrent-client/" + fileChosen.Path())
+	<-o.Task.Torrent.Process.GotInfo()
 
-	cache := Cache{Task: o.Task}
-	if cache.TrySend("video", pathway) {
-		return false
+	if folder, ok := ParseTorrentZip(o.Task.Message.Text); ok {
+		if o.Task.UserFromDB.Premium == 0 {
+			o.Task.PremiumAd("torrent")
+			return &TaskError{Key: "Available only for users who support us", Detail: "torrent zip"}
+		}
+
+		o.zip, o.folder = true, folder
+		o.Files = TorrentFolderFiles(o.Task.Torrent.Process.Files(), folder)
+	} else {
+		o.Files = ParseTorrentChoice(o.Task.Message.Text, o.Task.Torrent.Process.Files())
 	}
-	if cache.TrySendThroughMd5(pathway) {
-		return false
+	if len(o.Files) == 0 {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "file chosen is empty"}
 	}
-	if cache.TrySend("doc", o.Task.Torrent.Name+".torrent") {
+
+	for _, val := range o.Files {
+		// the archive is cut into volumes
+		if val.Length() > telegramMaxFileSize && !o.Task.SplitEnabled() && !o.zip {
+			_, err := Postgres.Exec(`DELETE FROM limits WHERE id = any 
+                         (array(SELECT id FROM limits WHERE telegram_id = $1 AND type_object = $2
+                                                      ORDER BY date_create DESC LIMIT 1))`,
+				o.Task.Message.From.ID, "torrent")
+			if err != nil {
+				log.Error(err)
+			}
+
+			return &TaskError{Key: "File is bigger 2 GB", Detail: val.Path()}
+		}
+	}
+
+	return nil
+}
+
+// raise - the files are downloaded now, the priority is lowered after them by this job only,
+// the other jobs of the same torrent keep their files
+func (o *ObjectTorrent) raise(files ...*torrent.File) {
+	for _, val := range files {
+		val.SetPriority(torrent.PiecePriorityNow)
+	}
+	o.raised = append(o.raised, files...)
+}
+
+// lowerRaised - the files raised by the job aren't downloaded anymore
+func (o *ObjectTorrent) lowerRaised() {
+	for _, val := range o.raised {
+		val.SetPriority(torrent.PiecePriorityNone)
+	}
+	o.raised = nil
+}
+
+// expectedSize - the data of the files which isn't on the disk yet, the zip archive is of the same size
+func (o *ObjectTorrent) expectedSize() int64 {
+	var size int64
+	for _, val := range o.Files {
+		size += val.Length() - val.BytesCompleted()
+		if o.zip {
+			size += val.Length()
+		}
+	}
+
+	return size
+}
+
+// Skip - the chosen files are one job, the failed file fails it
+func (o *ObjectTorrent) Skip(error) bool {
+	return false
+}
+
+// writtenSize - the bytes of the chosen files and of the zip written since the reservation
+func (o *ObjectTorrent) writtenSize() func() int64 {
+	completed := func() int64 {
+		var size int64
+		for _, val := range o.Files {
+			size += val.BytesCompleted()
+		}
+		return size
+	}
+	base, baseWorkspace := completed(), dirSize(o.Task.Workspace)
+
+	return func() int64 {
+		return completed() - base + dirSize(o.Task.Workspace) - baseWorkspace
+	}
+}
+
+// Next - the next chosen file, the task is reset for it
+func (o *ObjectTorrent) Next() bool {
+	if o.zip || o.current+1 >= len(o.Files) {
 		return false
 	}
+	o.current++
+	o.lowerRaised()
 
-	o.Task.File = pathway
+	o.Task.File = ""
+	o.Task.Files = nil
+	o.Task.FileConverted = FileConverted{}
+	o.Task.Torrent.Name = ""
+	o.Task.MessageTextLast = ""
 
 	return true
 }
 
-func (o *ObjectTorrent) Convert() bool {
+// zipName - the folder or the whole torrent
+func (o *ObjectTorrent) zipName() string {
+	if o.folder != "" {
+		return path.Base(o.folder)
+	}
+
+	return o.Task.Torrent.Process.Info().Name
+}
+
+// zipKey - the archive in the cache, info hash and the folder
+func (o *ObjectTorrent) zipKey() string {
+	return "torrent-zip/" + o.Task.Torrent.Process.InfoHash().HexString() + "/" +
+		path.Join(o.folder, o.zipName()) + ".zip"
+}
+
+func (o *ObjectTorrent) Convert() error {
+	if o.zip {
+		var entries []zipEntry
+		for _, val := range o.Files {
+			entries = append(entries, zipEntry{
+				Path: path.Clean(config.DirBot + "/torrent-client/" + val.Path()),
+				Name: path.Join(o.zipName(), strings.TrimPrefix(val.DisplayPath(), o.folder+"/")),
+			})
+		}
+
+		o.Task.Send(o.Task.EditProgress(fmt.Sprintf("🗜 "+o.Task.Lang("Packing the files into the zip")+" - %s",
+			o.Task.Torrent.Name)))
+
+		var err error
+		o.volumes, err = Split{Task: o.Task}.Zip(o.zipName()+".zip", entries)
+		if err != nil {
+			if o.Task.Stopped() {
+				return o.Task.StopError()
+			}
+			return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "zip " + o.zipName(), Err: err}
+		}
+
+		return nil
+	}
+
 	var c = Convert{Task: o.Task, IsTorrent: true}
 
-	if c.Task.IsAllowFormatForConvert(c.Task.File) {
-		o.Task.FileConverted = c.Run()
+	if !c.Task.IsAllowFormatForConvert(c.Task.File) {
+		return nil
+	}
 
-		return true
-	} else {
-		return false
+	if o.stream != "" {
+		defer func() {
+			o.closeStream()
+			o.stream = ""
+		}()
+
+		fileChosen := o.Files[o.current]
+		c.Input = o.stream
+		c.Downloaded = func() float64 {
+			return float64(fileChosen.BytesCompleted()) / float64(fileChosen.Length()) * 100
+		}
 	}
+
+	var err error
+	o.Task.FileConverted, err = c.Run()
+	if err != nil && o.stalled.Load() {
+		return &TaskError{Key: "Didn't have time to download, maximum 30 minutes or speed is low",
+			Retryable: true, Detail: o.Task.Torrent.Name, Err: err}
+	}
+
+	return err
 }
 
-func (o *ObjectTorrent) Send() bool {
+func (o *ObjectTorrent) Send() error {
+	if o.zip {
+		return o.Task.SendDocVolumes(o.volumes, o.zipKey())
+	}
+
 	if path.Ext(o.Task.File) == ".flac" ||
 		path.Ext(o.Task.File) == ".mp3" ||
 		path.Ext(o.Task.File) == ".ogg" ||
@@ -183,7 +435,17 @@ func (o *ObjectTorrent) Send() bool {
 	}
 
 	if path.Ext(o.Task.FileConverted.FilePath) == ".mp4" {
-		return o.Task.SendVideo(true)
+		if err := o.Task.SendVideo(true); err != nil {
+			return err
+		}
+
+		// once per job, the preview was sent instead of the full video
+		if o.Task.PreviewOnly() && !o.adSent {
+			o.adSent = true
+			o.Task.PremiumAd("torrent")
+		}
+
+		return nil
 	}
 
 	//if o.Task.UserFromDB.Premium == 0 {
@@ -194,5 +456,6 @@ func (o *ObjectTorrent) Send() bool {
 }
 
 func (o *ObjectTorrent) Clean() {
+	o.lowerRaised()
 	o.Task.RemoveMessageEdit()
 }
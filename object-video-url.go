@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	tgbotapi "github.com/krol44/telegram-bot-api"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"math"
 	"net/url"
@@ -12,144 +13,126 @@ import (
 	"os/exec"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-func (o *ObjectVideoUrl) Download() bool {
-	urlVideo := o.Task.Message.Text
-
-	allowUrls := []string{
-		"youtube.com",
-		"youtu.be",
-		"tiktok.com",
-		"vk.com/video",
-		"twitch.tv/videos",
-		"twitch.tv/*****/clip",
-		"rutube.ru/video",
-		"instagram.com/reel",
-		"coub.com/view",
-	}
-
-	var urlsForSend []string
-	urlsForSend = append(allowUrls, urlsForSend...)
-	urlsForSend = append(urlsForSend, []string{
-		"open.spotify.com/track",
-		"open.spotify.com/album",
-	}...)
-
-	var allowUrl bool
-	for _, val := range allowUrls {
-		if strings.Contains(urlVideo, "twitch.tv") && strings.Contains(urlVideo, "/clip") {
-			allowUrl = true
-		}
-		if strings.Contains(urlVideo, val) {
-			allowUrl = true
+func (o *ObjectVideoUrl) Download() error {
+	opts := o.Task.Options()
+	urlVideo := opts.Url
+
+	// the videos of the playlist are downloaded one by one, the resumed job goes on from its position
+	var batchStat string
+	if ids := opts.Playlist; ids != nil {
+		if o.entries == nil {
+			// the ids may be typed by hand, not chosen in the picker
+			o.entries = ids
+			if limit := o.Task.PlaylistMax(); len(o.entries) > limit {
+				o.entries = o.entries[:limit]
+			}
+			if position := o.Task.Job.Position; position > 0 && position < len(o.entries) {
+				o.current = position
+			}
 		}
-	}
-	if !allowUrl {
-		uFs := strings.Replace(strings.Join(urlsForSend, "\n"), "instagram.com/reel", "", 1)
-		m := tgbotapi.NewMessage(o.Task.Message.Chat.ID,
-			"❗️ "+o.Task.Lang("Not allowed url, I support only:")+"\n"+uFs)
-		m.DisableWebPagePreview = true
-		o.Task.Send(m)
-		return false
-	}
-
-	sp := strings.Split(o.Task.Message.Text, "&")
-	if len(sp) >= 1 {
-		urlVideo = sp[0]
+		urlVideo = PlaylistEntryUrl(o.entries[o.current])
+		batchStat = fmt.Sprintf("📦 %d / %d\n", o.current+1, len(o.entries))
 	}
 	o.Task.DescriptionUrl = urlVideo
 
 	_, err := url.ParseRequestURI(urlVideo)
 	if err != nil {
-		o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID, "❗️ "+o.Task.Lang("Video url is bad")))
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Video url is bad", Err: err}
 	}
 
-	if o.Task.Limit("video-url") {
-		log.Debug("limit exceeded")
-		return false
+	// the limit is counted for every video of the playlist, the slot is taken once
+	if err := o.Task.Limit(); err != nil {
+		return err
 	}
 
-	if !o.Task.Alloc("video-url") {
-		o.Task.App.SendLogToChannel(o.Task.Message.From, "mess", "❗️ return - error alloc")
-		return false
+	if !o.allocated {
+		if err := o.Task.Alloc(); err != nil {
+			return err
+		}
+		o.allocated = true
 	}
 
-	infoArgs := []string{"-j", "--socket-timeout", "10", urlVideo}
+	// the video of the link with the list is downloaded alone
+	infoArgs := []string{"-j", "--no-playlist", "--socket-timeout", "10", urlVideo}
 	if strings.Contains(o.Task.Message.Text, "instagram.com/reel") {
 		infoArgs = append(infoArgs, []string{"--cookies", "instagram-cookies.txt"}...)
 	}
 
-	cmd := exec.Command("yt-dlp", infoArgs...)
-	// protected
-	protectedFlag := true
-	go func(cmd *exec.Cmd, protectedFlag *bool) {
-		time.Sleep(10 * time.Second)
-		if *protectedFlag == true {
-			log.Warning("get info video url kill process")
-			cmd.Process.Kill()
-		}
-	}(cmd, &protectedFlag)
-
-	out, err := cmd.Output()
+	// protected, yt-dlp is killed after 10 seconds
+	ctxInfo, cancelInfo := context.WithTimeout(o.Task.Ctx, 10*time.Second)
+	out, err := exec.CommandContext(ctxInfo, "yt-dlp", infoArgs...).Output()
+	cancelInfo()
+	if o.Task.Stopped() {
+		return o.Task.StopError()
+	}
 
 	if err != nil {
-		o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID, "❗️ "+o.Task.Lang("Video url is bad")+" 1"))
-		o.Task.App.SendLogToChannel(o.Task.Message.From, "mess", "❗️ Video url is bad 1")
-		log.Warn(err)
-		return false
+		return &TaskError{Key: "Video url is bad", Retryable: true, Detail: "yt-dlp info - " + urlVideo, Err: err}
 	}
 
 	var infoVideo InfoYtDlp
 	err = json.Unmarshal(out, &infoVideo)
 	if err != nil {
-		o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID, "❗️ "+o.Task.Lang("Video url is bad")+" 2"))
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Video url is bad", Detail: "yt-dlp info json - " + urlVideo, Err: err}
 	}
 
 	if infoVideo.ID == "" {
-		o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID, "❗️ "+o.Task.Lang("Video url is bad")+" 3"))
-		log.Error("not found id - " + urlVideo)
-		return false
-	}
-
-	protectedFlag = false
-
-	if _, isSlice := o.Task.GetTimeSlice(); isSlice {
-		o.Task.Message.Text += " +skip-cache-id +quality"
+		return &TaskError{Key: "Video url is bad", Detail: "not found id - " + urlVideo}
 	}
+	o.info = infoVideo
 
 	u, err := url.Parse(urlVideo)
 	if err != nil {
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Video url is bad", Err: err}
 	}
 
 	o.Task.UrlIDForCache = strings.Split(strings.Replace(u.Host, "www.", "", 1), ".")[0] +
 		"-" + infoVideo.ID
+	if o.Task.AudioOnly() {
+		o.Task.UrlIDForCache = audioCacheID(o.Task.UrlIDForCache)
+	}
 	cache := Cache{Task: o.Task}
-	if !strings.Contains(o.Task.Message.Text, "+skip-cache-id") {
+	if !o.skipCache() {
 		if cache.TrySendThroughID() {
-			return false
+			return ErrSentFromCache
 		}
 	}
 
+	// the size of the format chosen by yt-dlp, the approx one if the exact is unknown
+	size := infoVideo.Filesize
+	if size == 0 {
+		size = infoVideo.FilesizeApprox
+	}
+	if err := o.Task.Reserve(int64(size), nil); err != nil {
+		return err
+	}
+
 	cleanTitle := strings.ReplaceAll(infoVideo.FullTitle, "#", "")
 
-	folder := config.DirBot + "/storage" + "/" + o.Task.UniqueId("files-video")
+	folder := o.Task.Workspace + "/" + o.Task.UniqueId("files-video")
 
 	quality := "bv*[ext=mp4]+ba[ext=m4a]/b[ext=mp4] / bv*+ba/b"
-	if strings.Contains(o.Task.Message.Text, "+quality") {
+	switch {
+	// the slice is cut from the best quality
+	case opts.Quality || opts.Slice != nil:
 		quality = "bv*+ba/b"
+	case opts.Format != "" && opts.Format != FormatAuto:
+		// the format of the picker, the default one if it is gone
+		quality = opts.Format + "/" + quality
+	case opts.Format == "" && o.Task.Settings.VideoQuality > 0:
+		quality = QualitySelector(o.Task.Settings.VideoQuality) + "/" + quality
 	}
 
 	if strings.Contains(o.Task.Message.Text, "coub.com/view") {
 		quality = "bestvideo,bestaudio"
+	} else if o.Task.AudioOnly() {
+		quality = "ba/b"
 	}
 
 	log.Debug(o.Task.Message.Text, " / ", quality)
@@ -173,6 +156,9 @@ func (o *ObjectVideoUrl) Download() bool {
 	if strings.Contains(o.Task.Message.Text, "instagram.com/reel") {
 		argsPre = append(argsPre, []string{"--cookies", "instagram-cookies.txt"}...)
 	}
+	if langs := o.subtitles(opts); langs != "" {
+		argsPre = append(argsPre, "--write-subs", "--write-auto-subs", "--sub-langs", langs, "--embed-subs")
+	}
 
 	var args []string
 	for _, v := range argsPre {
@@ -182,8 +168,14 @@ func (o *ObjectVideoUrl) Download() bool {
 		args = append(args, v)
 	}
 
-	cmd = exec.Command("yt-dlp", args...)
+	ctx, cancel := context.WithTimeout(o.Task.Ctx, time.Minute*20)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
 	defer func(c *exec.Cmd) {
+		if c.Process == nil {
+			return
+		}
 		c.Process.Kill()
 		if err := c.Wait(); err != nil {
 			log.Info(err)
@@ -191,52 +183,40 @@ func (o *ObjectVideoUrl) Download() bool {
 		c.Process.Release()
 	}(cmd)
 
-	stopProtected := false
-	go func(cmd *exec.Cmd, folder string, stopProtected *bool) {
+	// protected, kill the download if the folder doesn't grow for a minute
+	var isStalled atomic.Bool
+	ctxStalled, stalled := context.WithCancel(ctx)
+	defer stalled()
+	go func(folder string) {
 		var sizeSave int64
 		for {
-			time.Sleep(60 * time.Second)
-
-			if *stopProtected {
-				break
+			select {
+			case <-ctxStalled.Done():
+				return
+			case <-time.After(60 * time.Second):
 			}
-			size, _ := o.Task.DirSize(folder)
 
+			size, _ := o.Task.DirSize(folder)
 			if sizeSave == size {
 				log.Warning("kill cmd download video url")
-				o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID,
-					"❗️ "+o.Task.Lang("Video url is bad")+" 4"))
-				break
+				isStalled.Store(true)
+				cancel()
+				return
 			}
 			sizeSave = size
 		}
-	}(cmd, folder, &stopProtected)
+	}(folder)
 
 	stdout, err := cmd.StdoutPipe()
 	cmd.Stderr = cmd.Stdout
 	if err != nil {
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Something wrong... I will be fixing it", Err: err}
 	}
 	if err = cmd.Start(); err != nil {
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "yt-dlp start", Err: err}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*20)
-	defer cancel()
-
 	for {
-		select {
-		case <-ctx.Done():
-			o.Task.Send(tgbotapi.NewMessage(o.Task.Message.Chat.ID,
-				"😔 "+o.Task.Lang("Didn't have time to download")))
-			o.Task.App.SendLogToChannel(o.Task.Message.From, "mess",
-				"Didn't have time to download video url")
-			return false
-		default:
-		}
-
 		tmp := make([]byte, 1024*400)
 		_, err := stdout.Read(tmp)
 		if err != nil {
@@ -256,22 +236,20 @@ func (o *ObjectVideoUrl) Download() bool {
 		var percent = "0"
 		if len(matches) == 2 {
 			percent = strings.TrimSpace(matches[1])
+			if pf, err := strconv.ParseFloat(percent, 64); err == nil {
+				o.Task.SetProgress(pf)
+			}
 		}
 
 		if percent == "0" {
 			percent = "•• "
 		}
-		mess := fmt.Sprintf("🔽 %s \n\n🔥 "+o.Task.Lang("Download progress")+": %s%%",
-			cleanTitle, percent)
+		mess := fmt.Sprintf("%s🔽 %s \n\n🔥 "+o.Task.Lang("Download progress")+": %s%%",
+			batchStat, cleanTitle, percent)
 		if o.Task.MessageTextLast != mess {
-			o.Task.Send(tgbotapi.NewEditMessageText(o.Task.Message.Chat.ID, o.Task.MessageEditID, mess))
+			o.Task.Send(o.Task.EditProgress(mess))
 			o.Task.MessageTextLast = mess
 		}
-		if _, bo := o.Task.App.ChatsWork.StopTasks.Load(o.Task.Message.Chat.ID); bo {
-			stopProtected = true
-			return false
-		}
-
 		if percent == "100" {
 			break
 		}
@@ -279,16 +257,26 @@ func (o *ObjectVideoUrl) Download() bool {
 		time.Sleep(2 * time.Second)
 	}
 
+	stalled()
+	if o.Task.Stopped() {
+		return o.Task.StopError()
+	}
+	if isStalled.Load() {
+		return &TaskError{Key: "Video url is bad", Retryable: true, Detail: "download stalled - " + urlVideo}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return &TaskError{Key: "Didn't have time to download", Retryable: true, Detail: urlVideo}
+	}
+
 	if strings.Contains(o.Task.Message.Text, "coub.com/view") {
-		if !o.prepareCoub(folder) {
-			return false
+		if err := o.prepareCoub(folder); err != nil {
+			return &TaskError{Key: "Video url is bad", Detail: "coub - " + urlVideo, Err: err}
 		}
 	}
 
 	dir, err := os.ReadDir(folder)
 	if err != nil {
-		log.Error(err)
-		return false
+		return &TaskError{Key: "Video url is bad", Detail: urlVideo, Err: err}
 	}
 
 	var filePath string
@@ -304,25 +292,26 @@ func (o *ObjectVideoUrl) Download() bool {
 		break
 	}
 
-	stopProtected = true
+	if filePath == "" {
+		return &TaskError{Key: "Video url is bad", Detail: "no file - " + urlVideo}
+	}
 
-	if !strings.Contains(o.Task.Message.Text, "+skip-cache-id") {
+	if !o.skipCache() && !o.Task.AudioOnly() {
 		if cache.TrySendThroughMd5(filePath) {
-			return false
+			return ErrSentFromCache
 		}
 	}
 
 	o.Task.File = filePath
 
-	return true
+	return nil
 }
 
-func (o *ObjectVideoUrl) prepareCoub(folder string) bool {
+func (o *ObjectVideoUrl) prepareCoub(folder string) error {
 	if strings.Contains(o.Task.Message.Text, "coub.com/view") {
 		dir, err := os.ReadDir(folder)
 		if err != nil {
-			log.Error(err)
-			return false
+			return err
 		}
 
 		var (
@@ -332,7 +321,7 @@ func (o *ObjectVideoUrl) prepareCoub(folder string) bool {
 			pathMp4  string
 			pathName string
 		)
-		c := Convert{}
+		c := Convert{Task: o.Task}
 		for _, file := range dir {
 			if path.Ext(file.Name()) == ".mp3" {
 				pathMp3 = folder + "/" + file.Name()
@@ -349,7 +338,7 @@ func (o *ObjectVideoUrl) prepareCoub(folder string) bool {
 		}
 
 		if pathName == "" {
-			return false
+			return errors.New("coub video not found")
 		}
 
 		loop := fmt.Sprintf("%v", math.Floor(float64(as)/float64(vs)))
@@ -358,43 +347,146 @@ func (o *ObjectVideoUrl) prepareCoub(folder string) bool {
 		if config.IsDev {
 			ffmpegPath = "ffmpeg"
 		}
-		_, err = exec.Command(ffmpegPath,
+		_, err = exec.CommandContext(o.Task.Ctx, ffmpegPath,
 			"-v", "quiet", "-stream_loop", loop, "-t", fmt.Sprintf("%d", as), "-i", pathMp4, "-i", pathMp3,
 			"-c", "copy", folder+"/"+pathName+"-coub.mp4").Output()
 		if err != nil {
-			log.Warn(err)
-			return false
+			return errors.Wrap(err, "ffmpeg coub")
 		}
 
 		err = os.Remove(pathMp3)
 		if err != nil {
-			log.Error(err)
-			return false
+			return err
 		}
 		err = os.Remove(pathMp4)
 		if err != nil {
-			log.Error(err)
-			return false
+			return err
 		}
 	}
 
-	return true
+	return nil
 }
 
-func (o *ObjectVideoUrl) Convert() bool {
+func (o *ObjectVideoUrl) Convert() error {
+	if o.Task.AudioOnly() {
+		return o.convertAudio()
+	}
+
 	var c = Convert{Task: o.Task, IsTorrent: false}
 
-	if c.Task.IsAllowFormatForConvert(c.Task.File) {
-		o.Task.FileConverted = c.Run()
+	if !c.Task.IsAllowFormatForConvert(c.Task.File) {
+		return nil
+	}
+
+	var err error
+	o.Task.FileConverted, err = c.Run()
+
+	return err
+}
+
+func (o *ObjectVideoUrl) Send() error {
+	if o.Task.AudioOnly() {
+		// the button is pressed while the video is converting
+		if len(o.Task.Files) == 0 {
+			if err := o.convertAudio(); err != nil {
+				return err
+			}
+		}
+		o.Task.UrlIDForCache = audioCacheID(o.Task.UrlIDForCache)
+
+		return o.Task.SendAudio()
+	}
+
+	return o.Task.SendVideo(false)
+}
+
+// convertAudio - the audio of the downloaded file with the tags of the video
+func (o *ObjectVideoUrl) convertAudio() error {
+	o.Task.Send(o.Task.EditProgress("🎵 " + o.Task.Lang("Extracting the audio") + "..."))
+
+	meta := AudioMeta{Title: o.info.Track, Artist: o.info.Artist, Cover: o.info.Thumbnail}
+	if meta.Title == "" {
+		meta.Title = o.info.FullTitle
+	}
+	if meta.Artist == "" {
+		meta.Artist = o.info.Uploader
+	}
+
+	audio, err := Convert{Task: o.Task}.Audio(o.Task.File, meta)
+	if o.Task.Stopped() {
+		return o.Task.StopError()
+	}
+	if err != nil {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "audio - " + o.Task.File, Err: err}
+	}
+	o.Task.Files = []string{audio}
+
+	return nil
+}
+
+// skipCache - the +skip-cache-id flag, the slice is never sent from the cache
+func (o *ObjectVideoUrl) skipCache() bool {
+	opts := o.Task.Options()
+
+	return opts.SkipCache || opts.Slice != nil
+}
+
+// subtitles - the languages of the +subs flag for yt-dlp, the audio has no subtitles
+func (o *ObjectVideoUrl) subtitles(opts Options) string {
+	if opts.Subtitles == "" || o.Task.AudioOnly() {
+		return ""
+	}
+	if opts.Subtitles != SubtitlesAuto {
+		return opts.Subtitles
+	}
+
+	lang := o.Task.Translate.Code
+	if lang == "" {
+		lang = "en"
+	}
+
+	return lang + ".*"
+}
 
-		return true
-	} else {
+// Next - the next video of the playlist, the task is reset for it
+func (o *ObjectVideoUrl) Next() bool {
+	if o.current+1 >= len(o.entries) {
 		return false
 	}
+	o.current++
+	o.Task.Job.SetPosition(o.current)
+
+	// the files of the previous video are removed, its reservation is free for the next one
+	o.Task.EmptyWorkspace()
+	o.Task.ReleaseReserve()
+
+	o.Task.File = ""
+	o.Task.Files = nil
+	o.Task.FileConverted = FileConverted{}
+	o.Task.UrlIDForCache = ""
+	o.Task.MessageTextLast = ""
+
+	return true
 }
 
-func (o *ObjectVideoUrl) Send() bool {
-	return o.Task.SendVideo(false)
+// Skip - the failed video of the playlist is reported, the next one goes on.
+// The playlist stops if the slot isn't taken yet or the daily limit is exceeded
+func (o *ObjectVideoUrl) Skip(err error) bool {
+	te := AsTaskError(err)
+	if len(o.entries) == 0 || !o.allocated || te.Key == "limit exceeded, try again in 24 hours" {
+		return false
+	}
+
+	urlVideo := PlaylistEntryUrl(o.entries[o.current])
+	log.Warnf("job %d, video %s failed: %s", o.Task.Job.ID, urlVideo, err)
+	o.Task.App.SendLogToChannel(o.Task.Message.From, "mess", "❗️ "+urlVideo+" - "+err.Error())
+
+	mess := tgbotapi.NewMessage(o.Task.Message.Chat.ID, fmt.Sprintf("😔 %d / %d - ", o.current+1,
+		len(o.entries))+o.Task.Lang(te.Key)+te.Hint+"\n\n"+urlVideo)
+	mess.DisableWebPagePreview = true
+	o.Task.Send(mess)
+
+	return true
 }
 
 func (o *ObjectVideoUrl) Clean() {
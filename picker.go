@@ -0,0 +1,163 @@
+package main
+
+import (
+	"github.com/anacrolix/torrent"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pickerTimeout - the picker without the choice is closed
+const pickerTimeout = time.Hour
+
+// Picker - inline keyboard of the choice before the job, the buttons are "prefix:id:op:arg".
+// The picker is kept till the choice, the cancel or the timeout, then the job is queued with the choice
+type Picker interface {
+	base() *PickerBase
+	Render() (string, tgbotapi.InlineKeyboardMarkup)
+	// Press - the button of the picker, returns the answer for the user and whether the choice is done
+	Press(op string, arg string) (string, bool)
+	// Choose - the done choice for the job
+	Choose() PickerChoice
+	// Close - the picker is cancelled or expired without the choice
+	Close(a *App)
+}
+
+// PickerBase - the message of the user and of the picker, the buttons are pressed one by one
+type PickerBase struct {
+	ID        int64
+	Message   *tgbotapi.Message
+	Translate *Translate
+	MessageID int
+	// Url of the job, the choice goes after it, empty for the torrent
+	Url string
+	// Flags of the message, they go to the job after the choice
+	Flags string
+
+	mu sync.Mutex
+}
+
+func (p *PickerBase) base() *PickerBase {
+	return p
+}
+
+// PickerChoice - the text of the picker message after the choice, the choice for the text of the job
+type PickerChoice struct {
+	Text    string
+	Choice  string
+	Answer  string
+	Torrent *torrent.Torrent
+}
+
+// OpenPicker sends the picker and keeps it by id, false - the picker isn't sent
+func (t *Task) OpenPicker(p Picker) bool {
+	b := p.base()
+	b.ID = t.App.ChatsWork.PickerSeq.Add(1)
+	b.Message, b.Translate = t.Message, t.Translate
+
+	text, keyboard := p.Render()
+	msg := tgbotapi.NewMessage(t.Message.Chat.ID, text)
+	msg.ReplyMarkup = keyboard
+	msg.DisableWebPagePreview = true
+	mess, isErr := t.Send(msg)
+	if isErr {
+		return false
+	}
+	b.MessageID = mess.MessageID
+
+	t.App.ChatsWork.Pickers.Store(b.ID, p)
+
+	time.AfterFunc(pickerTimeout, func() {
+		if _, ok := t.App.ChatsWork.Pickers.LoadAndDelete(b.ID); ok {
+			p.Close(t.App)
+			t.App.Bot.Send(tgbotapi.NewDeleteMessage(t.Message.Chat.ID, b.MessageID))
+		}
+	})
+
+	return true
+}
+
+// splitPickerData - data of the button is id:op:arg
+func splitPickerData(data string) (id int64, op string, arg string) {
+	sp := strings.SplitN(data, ":", 3)
+	id, _ = strconv.ParseInt(sp[0], 10, 64)
+	if len(sp) > 1 {
+		op = sp[1]
+	}
+	if len(sp) > 2 {
+		arg = sp[2]
+	}
+
+	return id, op, arg
+}
+
+// PickerCallback - data is id:op:arg, expired is the answer if the picker is closed already.
+// Only the user of the message presses the buttons, "n" is the page number, "x" is the cancel
+func (a *App) PickerCallback(cq *tgbotapi.CallbackQuery, data string, expired string) string {
+	tr := &Translate{Code: cq.From.LanguageCode}
+
+	id, op, arg := splitPickerData(data)
+	val, ok := a.ChatsWork.Pickers.Load(id)
+	if !ok {
+		a.Bot.Send(tgbotapi.NewDeleteMessage(cq.Message.Chat.ID, cq.Message.MessageID))
+		return tr.Lang(expired)
+	}
+	p := val.(Picker)
+	b := p.base()
+	if b.Message.From.ID != cq.From.ID {
+		return ""
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch op {
+	case "n":
+		return ""
+	case "x":
+		if _, ok := a.ChatsWork.Pickers.LoadAndDelete(b.ID); ok {
+			p.Close(a)
+		}
+		a.Bot.Send(tgbotapi.NewDeleteMessage(cq.Message.Chat.ID, b.MessageID))
+		return ""
+	}
+
+	answer, done := p.Press(op, arg)
+	if !done {
+		text, keyboard := p.Render()
+		edit := tgbotapi.NewEditMessageTextAndMarkup(cq.Message.Chat.ID, b.MessageID, text, keyboard)
+		edit.DisableWebPagePreview = true
+		if _, err := a.Bot.Send(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+			log.Warn(err)
+		}
+		return answer
+	}
+
+	if _, ok := a.ChatsWork.Pickers.LoadAndDelete(b.ID); !ok {
+		return ""
+	}
+
+	choice := p.Choose()
+	a.Bot.Send(tgbotapi.NewEditMessageText(cq.Message.Chat.ID, b.MessageID, choice.Text))
+
+	// the job is created by the queue, the choice is kept with the flags of the job
+	message := *b.Message
+	message.Text = strings.TrimPrefix(b.Url+" "+choice.Choice, " ") + b.Flags
+	message.Entities = nil
+	a.Queue <- QueueMessages{Message: &message, Torrent: choice.Torrent}
+
+	return choice.Answer
+}
+
+// pickerLabel - the end of the long name is more useful, there are episode numbers
+func pickerLabel(label string) string {
+	r := []rune(label)
+	if len(r) <= 60 {
+		return label
+	}
+
+	return string(r[:2]) + "…" + string(r[len(r)-57:])
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	playlistPickerPage = 8
+	// playlistMaxEntries - entries of the long playlist or the channel shown in the picker
+	playlistMaxEntries = 500
+)
+
+// PlaylistPicker - inline keyboard with the videos of the playlist or the channel, the user checks videos
+// and starts one job for all of them, the count of videos is limited by the tier of the user
+type PlaylistPicker struct {
+	PickerBase
+	Title   string
+	Entries []PlaylistEntry
+	// Max - videos in one job
+	Max int
+
+	page     int
+	selected map[int]bool
+}
+
+type PlaylistEntry struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	Duration float64 `json:"duration"`
+}
+
+type infoPlaylist struct {
+	Title   string          `json:"title"`
+	Entries []PlaylistEntry `json:"entries"`
+}
+
+// IsPlaylistUrl - the playlist or the channel of youtube, the video of the playlist is downloaded alone
+func IsPlaylistUrl(text string) bool {
+	link, _, _ := strings.Cut(text, " ")
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+
+	host := strings.TrimPrefix(strings.TrimPrefix(u.Host, "www."), "m.")
+	if host != "youtube.com" {
+		return false
+	}
+
+	if u.Path == "/playlist" && u.Query().Get("list") != "" {
+		return true
+	}
+	for _, prefix := range []string{"/@", "/channel/", "/c/", "/user/"} {
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PlaylistChoice - ids of the chosen videos for the job, "playlist:id1,id2"
+func PlaylistChoice(ids []string) string {
+	return "playlist:" + strings.Join(ids, ",")
+}
+
+// ParsePlaylistChoice - ids of the chosen videos in the text of the job
+func ParsePlaylistChoice(text string) ([]string, bool) {
+	o, _ := ParseOptions(text)
+
+	return o.Playlist, o.Playlist != nil
+}
+
+// PlaylistEntryUrl - the video of the playlist
+func PlaylistEntryUrl(id string) string {
+	return "https://www.youtube.com/watch?v=" + url.QueryEscape(id)
+}
+
+// PlaylistMax - videos of the playlist in one job
+func (t *Task) PlaylistMax() int {
+	if t.UserFromDB.Premium == 1 {
+		return config.PlaylistMaxPremium
+	}
+
+	return config.PlaylistMaxFree
+}
+
+// OpenPlaylistPicker gets the videos of the playlist and sends the picker, the job starts when they are chosen
+func (t *Task) OpenPlaylistPicker() {
+	link, flags := t.Options().Url, t.pickerFlags()
+
+	t.App.SendLogToChannel(t.Message.From, "mess", "playlist - "+link)
+	m, _ := t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "🕚 "+t.Lang("Getting the list of videos, please wait")))
+
+	ctx, cancel := context.WithTimeout(t.Ctx, time.Minute)
+	out, err := exec.CommandContext(ctx, "yt-dlp", "-J", "--flat-playlist", "--socket-timeout", "10",
+		"--playlist-end", strconv.Itoa(playlistMaxEntries), link).Output()
+	cancel()
+	t.App.Bot.Send(tgbotapi.NewDeleteMessage(t.Message.Chat.ID, m.MessageID))
+
+	var info infoPlaylist
+	if err == nil {
+		err = json.Unmarshal(out, &info)
+	}
+	if err != nil || len(info.Entries) == 0 {
+		if err != nil {
+			log.Warn(err)
+		}
+		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "😔 "+t.Lang("Video url is bad")))
+		t.App.SendLogToChannel(t.Message.From, "mess", "error playlist - "+link)
+		return
+	}
+
+	p := &PlaylistPicker{
+		PickerBase: PickerBase{Url: link, Flags: flags},
+		Title:      info.Title,
+		Entries:    info.Entries,
+		Max:        t.PlaylistMax(),
+		selected:   map[int]bool{},
+	}
+	t.OpenPicker(p)
+}
+
+// Selected - ids of the checked videos in the order of the playlist
+func (p *PlaylistPicker) Selected() []string {
+	var ids []string
+	for i, e := range p.Entries {
+		if p.selected[i] {
+			ids = append(ids, e.ID)
+		}
+	}
+
+	return ids
+}
+
+func (p *PlaylistPicker) Render() (string, tgbotapi.InlineKeyboardMarkup) {
+	tr := p.Translate
+
+	text := "📍 " + tr.Lang("Choose videos of the playlist") + "\n\n▶️ " + p.Title +
+		fmt.Sprintf("\n\n✅ %s: %d / %d", tr.Lang("Selected videos"), len(p.selected), p.Max)
+
+	pages := (len(p.Entries) + playlistPickerPage - 1) / playlistPickerPage
+	if p.page >= pages {
+		p.page = pages - 1
+	}
+	if p.page < 0 {
+		p.page = 0
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := p.page * playlistPickerPage; i < len(p.Entries) && i < (p.page+1)*playlistPickerPage; i++ {
+		e := p.Entries[i]
+
+		check := "☑️"
+		if p.selected[i] {
+			check = "✅"
+		}
+		label := fmt.Sprintf("%s %d. %s", check, i+1, e.Title)
+		if e.Duration > 0 {
+			label += " ~ " + (time.Duration(e.Duration) * time.Second).String()
+		}
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(pickerLabel(label), fmt.Sprintf("pl:%d:f:%d", p.ID, i))))
+	}
+
+	if pages > 1 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("pl:%d:p:%d", p.ID, p.page-1)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d / %d", p.page+1, pages),
+				fmt.Sprintf("pl:%d:n", p.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("pl:%d:p:%d", p.ID, p.page+1)),
+		))
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🎬 %s %d", tr.Lang("First"), p.Max),
+			fmt.Sprintf("pl:%d:a", p.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("✖️ "+tr.Lang("Clear"), fmt.Sprintf("pl:%d:c", p.ID))))
+
+	var final []tgbotapi.InlineKeyboardButton
+	if len(p.selected) > 0 {
+		final = append(final, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("📥 %s (%d)", tr.Lang("Download"), len(p.selected)), fmt.Sprintf("pl:%d:g", p.ID)))
+	}
+	final = append(final, tgbotapi.NewInlineKeyboardButtonData("❌ "+tr.Lang("Cancel"),
+		fmt.Sprintf("pl:%d:x", p.ID)))
+	rows = append(rows, final)
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// Press - the button of the picker, returns the answer for the user and whether the picker is finished
+func (p *PlaylistPicker) Press(op string, arg string) (string, bool) {
+	tr := p.Translate
+	num, _ := strconv.Atoi(arg)
+
+	switch op {
+	case "f":
+		if num < 0 || num >= len(p.Entries) {
+			return "", false
+		}
+		if p.selected[num] {
+			delete(p.selected, num)
+			break
+		}
+		if len(p.selected) >= p.Max {
+			return fmt.Sprintf(tr.Lang("Max videos in one task: %d"), p.Max), false
+		}
+		p.selected[num] = true
+	case "p":
+		p.page = num
+	case "a":
+		p.selected = map[int]bool{}
+		for i := 0; i < len(p.Entries) && i < p.Max; i++ {
+			p.selected[i] = true
+		}
+	case "c":
+		p.selected = map[int]bool{}
+	case "g":
+		if len(p.selected) == 0 {
+			return "", false
+		}
+		return "", true
+	}
+
+	return "", false
+}
+
+// Choose - the ids of the checked videos go to the job
+func (p *PlaylistPicker) Choose() PickerChoice {
+	ids := p.Selected()
+
+	return PickerChoice{
+		Text:   fmt.Sprintf("📥 %s\n%s: %d", p.Title, p.Translate.Lang("Selected videos"), len(ids)),
+		Choice: PlaylistChoice(ids),
+	}
+}
+
+func (p *PlaylistPicker) Close(*App) {}
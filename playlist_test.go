@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsPlaylistUrl(t *testing.T) {
+	for text, want := range map[string]bool{
+		"https://www.youtube.com/playlist?list=PL123":            true,
+		"https://youtube.com/@channel/videos +quality":           true,
+		"https://m.youtube.com/channel/UC123":                    true,
+		"https://www.youtube.com/watch?v=abc&list=PL123":         false,
+		"https://www.youtube.com/playlist":                       false,
+		"https://vimeo.com/@channel":                             false,
+		"https://www.youtube.com/playlist?list=PL1 playlist:a,b": true,
+	} {
+		if got := IsPlaylistUrl(text); got != want {
+			t.Errorf("%s - %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestPlaylistPickerPress(t *testing.T) {
+	p := &PlaylistPicker{
+		PickerBase: PickerBase{Translate: &Translate{Code: "en"}},
+		Entries:    []PlaylistEntry{{ID: "a"}, {ID: "b"}, {ID: "c"}, {ID: "d"}},
+		Max:        2,
+		selected:   map[int]bool{},
+	}
+
+	if _, done := p.Press("g", ""); done {
+		t.Error("nothing is chosen, the picker is done")
+	}
+
+	p.Press("f", "3")
+	p.Press("f", "1")
+	if answer, _ := p.Press("f", "0"); answer == "" || len(p.selected) != 2 {
+		t.Errorf("over the max - %q, selected %v", answer, p.selected)
+	}
+	p.Press("f", "9")
+	if got := p.Selected(); !reflect.DeepEqual(got, []string{"b", "d"}) {
+		t.Errorf("selected %v, want the order of the playlist", got)
+	}
+
+	p.Press("a", "")
+	if got := p.Selected(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("the first max - %v", got)
+	}
+	if _, done := p.Press("g", ""); !done {
+		t.Error("the chosen picker isn't done")
+	}
+
+	p.Press("c", "")
+	if got := p.Selected(); got != nil {
+		t.Errorf("cleared - %v", got)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	previewDuration     = 5 * time.Minute
+	previewCardDuration = 5 * time.Second
+)
+
+// PreviewOnly - free users get the first 5 minutes of torrent videos with the watermark,
+// premium users get the full video
+func (t *Task) PreviewOnly() bool {
+	return t.Source != nil && t.Source.Name == "torrent" && t.PreviewUser()
+}
+
+// PreviewUser - the user doesn't support the bot, videos of torrents are previews
+func (t *Task) PreviewUser() bool {
+	return t.UserFromDB.Premium == 0
+}
+
+// previewTimeTotal - duration of the preview with the end card, for the progress of the convert
+func previewTimeTotal(timeTotal time.Time) time.Time {
+	timeNull, _ := time.Parse("15:04:05", "00:00:00")
+	if timeTotal.Sub(timeNull) > previewDuration {
+		timeTotal = timeNull.Add(previewDuration)
+	}
+
+	return timeTotal.Add(previewCardDuration)
+}
+
+// previewArgs - ffmpeg cuts the first minutes, draws the watermark over them
+// and appends the end card inviting to support the bot
+func (c Convert) previewArgs(cv string, bitrate int, fileConvertPath string, fileConvertPathOut string) ([]string,
+	error) {
+	// texts are passed in files, the filter graph escaping isn't needed
+	folder := c.Task.Workspace + "/" + c.Task.UniqueId("files-preview")
+	if err := os.Mkdir(folder, os.ModePerm); err != nil {
+		return nil, err
+	}
+	texts := map[string]string{
+		"watermark": "@TorPurrBot " + c.Task.Lang("preview"),
+		"card":      c.Task.Lang("Only the first 5 minutes video is available"),
+		"card-next": c.Task.Lang("Full video is available for users who support us") + " - /info",
+	}
+	for name, text := range texts {
+		if err := os.WriteFile(folder+"/"+name+".txt", []byte(text), 0666); err != nil {
+			return nil, err
+		}
+	}
+
+	cardSeconds := strconv.Itoa(int(previewCardDuration.Seconds()))
+
+	filter := "[0:v]scale=w='min(1920\\, iw*3/2):h=-2',setsar=1," +
+		"drawtext=textfile=" + folder + "/watermark.txt:expansion=none:fontcolor=white@0.6:fontsize=h/24:" +
+		"x=w-tw-h/30:y=h/30:box=1:boxcolor=black@0.3:boxborderw=8,format=yuv420p[main];" +
+		"[1:v][main]scale2ref[card][mainref];" +
+		"[card]setsar=1," +
+		"drawtext=textfile=" + folder + "/card.txt:expansion=none:fontcolor=white:fontsize=h/16:" +
+		"x=(w-tw)/2:y=h/2-th-h/40," +
+		"drawtext=textfile=" + folder + "/card-next.txt:expansion=none:fontcolor=white@0.8:fontsize=h/24:" +
+		"x=(w-tw)/2:y=h/2+h/40,format=yuv420p[end];"
+
+	audio, err := c.hasAudio(fileConvertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := []string{"-map", "[v]"}
+	if audio {
+		filter += "[0:a]aformat=sample_rates=44100:channel_layouts=stereo[a0];" +
+			"[mainref][a0][end][2:a]concat=n=2:v=1:a=1[v][a]"
+		mapping = append(mapping, "-map", "[a]")
+	} else {
+		filter += "[mainref][end]concat=n=2:v=1:a=0[v]"
+	}
+
+	args := []string{
+		"-protocol_whitelist", protocols(fileConvertPath) + ",lavfi",
+		"-v", "quiet",
+		"-hide_banner", "-stats",
+		"-t", strconv.Itoa(int(previewDuration.Seconds())),
+		"-i", fileConvertPath,
+		"-f", "lavfi", "-t", cardSeconds, "-i", "color=c=0x1b1b1b:s=1280x720:r=25",
+		"-f", "lavfi", "-t", cardSeconds, "-i", "anullsrc=r=44100:cl=stereo",
+		"-filter_complex", filter,
+	}
+	args = append(args, mapping...)
+	args = append(args,
+		"-acodec", "aac",
+		"-c:v", cv,
+		"-preset", "medium",
+		"-pix_fmt", "yuv420p",
+		"-b:v", fmt.Sprintf("%d", bitrate),
+		"-b:a", "192k",
+		"-y",
+		"-f", "mp4",
+		fileConvertPathOut)
+
+	return args, nil
+}
+
+// hasAudio - the video without the audio is joined with the card without the audio too
+func (c Convert) hasAudio(pathway string) (bool, error) {
+	out, err := exec.CommandContext(c.Task.Ctx, "ffprobe",
+		"-protocol_whitelist", protocols(pathway),
+		"-v", "error",
+		"-select_streams", "a",
+		"-show_entries", "stream=index",
+		"-of", "csv=p=0",
+		pathway).Output()
+	if err != nil {
+		return false, fmt.Errorf("ffprobe audio - %w", err)
+	}
+
+	return strings.TrimSpace(string(out)) != "", nil
+}
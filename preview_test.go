@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreviewTimeTotal(t *testing.T) {
+	cases := map[string]string{
+		"01:30:00": "00:05:05",
+		"00:05:00": "00:05:05",
+		"00:02:10": "00:02:15",
+	}
+
+	for total, want := range cases {
+		timeTotal, _ := time.Parse("15:04:05", total)
+
+		if got := previewTimeTotal(timeTotal).Format("15:04:05"); got != want {
+			t.Errorf("%s - preview %s, want %s", total, got, want)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ticketWaiting = iota
+	ticketRunning
+	ticketDone
+)
+
+// Scheduler gives worker slots out per class: premium users first, then round-robin across users.
+// A ticket with UserLimit waits while the user already runs that many limited tickets in any class
+type Scheduler struct {
+	mu          sync.Mutex
+	seq         int64
+	classes     map[string]*schedulerClass
+	userRunning map[int64]int
+	closed      bool
+}
+
+type schedulerClass struct {
+	workers int
+	running []*Ticket
+	waiting []*Ticket
+	// user -> turn when the user was served last time
+	served  map[int64]int64
+	turn    int64
+	avgWork time.Duration
+}
+
+type Ticket struct {
+	Class     string
+	JobID     int64
+	UserID    int64
+	ChatID    int64
+	Premium   bool
+	UserLimit int
+
+	seq     int64
+	state   int
+	started time.Time
+	ready   chan struct{}
+	changed chan struct{}
+}
+
+func NewScheduler(workers map[string]int) *Scheduler {
+	s := &Scheduler{classes: map[string]*schedulerClass{}, userRunning: map[int64]int{}}
+	for class, w := range workers {
+		s.classes[class] = &schedulerClass{workers: w, served: map[int64]int64{}}
+	}
+
+	return s
+}
+
+func (s *Scheduler) class(name string) *schedulerClass {
+	c, ok := s.classes[name]
+	if !ok {
+		c = &schedulerClass{workers: 1, served: map[int64]int64{}}
+		s.classes[name] = c
+	}
+
+	return c
+}
+
+// Enqueue puts the ticket at the end of its class. The released ticket may be enqueued again (the task waited for
+// the disk), it gets the new place behind the waiting tickets, the round-robin turn of the user is kept only while
+// the user has other tickets in the class
+func (s *Scheduler) Enqueue(t *Ticket) *Ticket {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	t.seq = s.seq
+	t.state = ticketWaiting
+	t.ready = make(chan struct{})
+	t.changed = make(chan struct{}, 1)
+
+	if s.closed && !s.jobRunning(t.JobID) {
+		t.state = ticketDone
+		close(t.ready)
+		return t
+	}
+
+	c := s.class(t.Class)
+	if _, ok := c.served[t.UserID]; !ok {
+		c.served[t.UserID] = 0
+	}
+	c.waiting = append(c.waiting, t)
+	s.dispatch()
+
+	return t
+}
+
+// Wait blocks until the ticket gets a slot, false - the ticket was cancelled
+func (s *Scheduler) Wait(t *Ticket, onChange func(position int, eta time.Duration)) bool {
+	for {
+		select {
+		case <-t.ready:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return t.state == ticketRunning
+		case <-t.changed:
+			if onChange != nil {
+				position, eta := s.Position(t)
+				if position > 0 {
+					onChange(position, eta)
+				}
+			}
+		}
+	}
+}
+
+// Release frees the slot of a running ticket or removes a waiting one
+func (s *Scheduler) Release(t *Ticket) {
+	if t == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.class(t.Class)
+	switch t.state {
+	case ticketWaiting:
+		c.waiting = removeTicket(c.waiting, t)
+		t.state = ticketDone
+		close(t.ready)
+	case ticketRunning:
+		c.running = removeTicket(c.running, t)
+		t.state = ticketDone
+		if t.UserLimit > 0 {
+			s.userRunning[t.UserID]--
+			if s.userRunning[t.UserID] <= 0 {
+				delete(s.userRunning, t.UserID)
+			}
+		}
+
+		work := time.Since(t.started)
+		if c.avgWork == 0 {
+			c.avgWork = work
+		} else {
+			c.avgWork = (c.avgWork*4 + work) / 5
+		}
+	default:
+		return
+	}
+
+	if !c.hasUser(t.UserID) {
+		delete(c.served, t.UserID)
+	}
+
+	s.dispatch()
+}
+
+// Cancel drops waiting tickets of the job, running tickets stay until Release
+func (s *Scheduler) Cancel(jobID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.classes {
+		var cancelled bool
+		for _, t := range append([]*Ticket{}, c.waiting...) {
+			if t.JobID != jobID {
+				continue
+			}
+			c.waiting = removeTicket(c.waiting, t)
+			t.state = ticketDone
+			close(t.ready)
+			cancelled = true
+		}
+		if cancelled {
+			c.notify()
+		}
+	}
+}
+
+// Close drops waiting tickets of the jobs which have not started yet and refuses new such jobs,
+// tickets of the running jobs (e.g. convert after download) are still given out
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, c := range s.classes {
+		var cancelled bool
+		for _, t := range append([]*Ticket{}, c.waiting...) {
+			if s.jobRunning(t.JobID) {
+				continue
+			}
+			c.waiting = removeTicket(c.waiting, t)
+			t.state = ticketDone
+			close(t.ready)
+			cancelled = true
+		}
+		if cancelled {
+			c.notify()
+		}
+	}
+}
+
+func (s *Scheduler) jobRunning(jobID int64) bool {
+	for _, c := range s.classes {
+		for _, t := range c.running {
+			if t.JobID == jobID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Position - place in the queue (0 if the ticket is not waiting) and approximate waiting time
+func (s *Scheduler) Position(t *Ticket) (int, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.state != ticketWaiting {
+		return 0, 0
+	}
+
+	c := s.class(t.Class)
+
+	served := make(map[int64]int64, len(c.served))
+	for k, v := range c.served {
+		served[k] = v
+	}
+	userRunning := make(map[int64]int, len(s.userRunning))
+	for k, v := range s.userRunning {
+		userRunning[k] = v
+	}
+	waiting := append([]*Ticket{}, c.waiting...)
+
+	turn := c.turn
+	position := 1
+	for ; len(waiting) > 0; position++ {
+		i := nextTicket(waiting, served, userRunning)
+		// the rest is blocked by the user limits
+		if i < 0 {
+			break
+		}
+		if waiting[i] == t {
+			break
+		}
+
+		turn++
+		served[waiting[i].UserID] = turn
+		if waiting[i].UserLimit > 0 {
+			userRunning[waiting[i].UserID]++
+		}
+		waiting = append(waiting[:i], waiting[i+1:]...)
+	}
+
+	var eta time.Duration
+	if c.workers > 0 {
+		eta = c.avgWork * time.Duration(position) / time.Duration(c.workers)
+	}
+
+	return position, eta
+}
+
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var l int
+	for _, c := range s.classes {
+		l += len(c.running) + len(c.waiting)
+	}
+
+	return l
+}
+
+func (s *Scheduler) dispatch() {
+	for _, c := range s.classes {
+		for len(c.running) < c.workers {
+			i := nextTicket(c.waiting, c.served, s.userRunning)
+			if i < 0 {
+				break
+			}
+			t := c.waiting[i]
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+
+			c.turn++
+			c.served[t.UserID] = c.turn
+			if t.UserLimit > 0 {
+				s.userRunning[t.UserID]++
+			}
+
+			t.state = ticketRunning
+			t.started = time.Now()
+			c.running = append(c.running, t)
+			close(t.ready)
+		}
+
+		c.notify()
+	}
+}
+
+func (c *schedulerClass) notify() {
+	for _, t := range c.waiting {
+		select {
+		case t.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *schedulerClass) hasUser(userID int64) bool {
+	for _, t := range append(append([]*Ticket{}, c.running...), c.waiting...) {
+		if t.UserID == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextTicket - index of the ticket to start, -1 if every waiting ticket is blocked by the user limit
+func nextTicket(waiting []*Ticket, served map[int64]int64, userRunning map[int64]int) int {
+	best := -1
+	for i, a := range waiting {
+		if a.UserLimit > 0 && userRunning[a.UserID] >= a.UserLimit {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+
+		b := waiting[best]
+		if a.Premium != b.Premium {
+			if a.Premium {
+				best = i
+			}
+			continue
+		}
+		if served[a.UserID] != served[b.UserID] {
+			if served[a.UserID] < served[b.UserID] {
+				best = i
+			}
+			continue
+		}
+		if a.seq < b.seq {
+			best = i
+		}
+	}
+
+	return best
+}
+
+func removeTicket(tickets []*Ticket, t *Ticket) []*Ticket {
+	for i, val := range tickets {
+		if val == t {
+			return append(tickets[:i], tickets[i+1:]...)
+		}
+	}
+
+	return tickets
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	s := NewScheduler(map[string]int{"video-url": 2})
+	var sw sync.WaitGroup
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	for c := 1; c <= 1000; c++ {
+		sw.Add(1)
+		go func(c int) {
+			defer sw.Done()
+
+			time.Sleep(time.Millisecond * time.Duration(rand.Intn(500)))
+			ticket := s.Enqueue(&Ticket{Class: "video-url", JobID: int64(c), UserID: int64(c % 10),
+				ChatID: int64(c % 10), Premium: c%7 == 0})
+			if !s.Wait(ticket, nil) {
+				t.Errorf("ticket %d cancelled", c)
+				return
+			}
+
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond * time.Duration(rand.Intn(5)))
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			s.Release(ticket)
+		}(c)
+	}
+
+	sw.Wait()
+
+	if s.Len() != 0 {
+		t.Errorf("error scheduler len - %d", s.Len())
+	}
+	if maxRunning > 2 {
+		t.Errorf("error scheduler workers - %d", maxRunning)
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	s := NewScheduler(map[string]int{"torrent": 1})
+
+	busy := s.Enqueue(&Ticket{Class: "torrent", JobID: 1, UserID: 100})
+
+	a1 := s.Enqueue(&Ticket{Class: "torrent", JobID: 2, UserID: 1})
+	a2 := s.Enqueue(&Ticket{Class: "torrent", JobID: 3, UserID: 1})
+	b1 := s.Enqueue(&Ticket{Class: "torrent", JobID: 4, UserID: 2})
+	p1 := s.Enqueue(&Ticket{Class: "torrent", JobID: 5, UserID: 3, Premium: true})
+
+	want := []*Ticket{p1, a1, b1, a2}
+	for i, ticket := range want {
+		if position, _ := s.Position(ticket); position != i+1 {
+			t.Errorf("ticket %d - position %d, want %d", i, position, i+1)
+		}
+	}
+
+	s.Cancel(b1.JobID)
+	if s.Wait(b1, nil) {
+		t.Error("cancelled ticket got a slot")
+	}
+
+	s.Release(busy)
+	for _, ticket := range []*Ticket{p1, a1, a2} {
+		if !s.Wait(ticket, nil) {
+			t.Error("ticket cancelled")
+		}
+		s.Release(ticket)
+	}
+
+	if s.Len() != 0 {
+		t.Errorf("error scheduler len - %d", s.Len())
+	}
+}
+
+func TestSchedulerEnqueueAgain(t *testing.T) {
+	s := NewScheduler(map[string]int{"torrent": 1})
+
+	a := s.Enqueue(&Ticket{Class: "torrent", JobID: 1, UserID: 1})
+	b := s.Enqueue(&Ticket{Class: "torrent", JobID: 2, UserID: 2})
+
+	// the slot is given away while the task waits for the disk
+	s.Release(a)
+	if !s.Wait(b, nil) {
+		t.Fatal("ticket cancelled")
+	}
+	c := s.Enqueue(&Ticket{Class: "torrent", JobID: 3, UserID: 3})
+
+	s.Enqueue(a)
+	for i, ticket := range []*Ticket{c, a} {
+		if position, _ := s.Position(ticket); position != i+1 {
+			t.Errorf("ticket %d - position %d, want %d", ticket.JobID, position, i+1)
+		}
+	}
+
+	for _, ticket := range []*Ticket{b, c, a} {
+		if !s.Wait(ticket, nil) {
+			t.Error("ticket cancelled")
+		}
+		s.Release(ticket)
+	}
+	if s.Len() != 0 {
+		t.Errorf("error scheduler len - %d", s.Len())
+	}
+}
+
+func TestSchedulerUserLimit(t *testing.T) {
+	s := NewScheduler(map[string]int{"video-url": 5, "torrent": 5})
+
+	v1 := s.Enqueue(&Ticket{Class: "video-url", JobID: 1, UserID: 1, UserLimit: 2})
+	t1 := s.Enqueue(&Ticket{Class: "torrent", JobID: 2, UserID: 1, UserLimit: 2})
+	v2 := s.Enqueue(&Ticket{Class: "video-url", JobID: 3, UserID: 1, UserLimit: 2})
+	o1 := s.Enqueue(&Ticket{Class: "video-url", JobID: 4, UserID: 2, UserLimit: 2})
+
+	for _, ticket := range []*Ticket{v1, t1, o1} {
+		if position, _ := s.Position(ticket); position != 0 {
+			t.Errorf("ticket %d waits, position %d", ticket.JobID, position)
+		}
+	}
+	if position, _ := s.Position(v2); position != 1 {
+		t.Errorf("ticket over the user limit - position %d, want 1", position)
+	}
+
+	s.Release(t1)
+	if !s.Wait(v2, nil) {
+		t.Error("ticket cancelled")
+	}
+
+	for _, ticket := range []*Ticket{v1, v2, o1} {
+		s.Release(ticket)
+	}
+	if s.Len() != 0 {
+		t.Errorf("error scheduler len - %d", s.Len())
+	}
+}
+
+func TestSchedulerClose(t *testing.T) {
+	s := NewScheduler(map[string]int{"video-url": 1, "convert": 1})
+
+	running := s.Enqueue(&Ticket{Class: "video-url", JobID: 1, UserID: 1})
+	waiting := s.Enqueue(&Ticket{Class: "video-url", JobID: 2, UserID: 2})
+
+	s.Close()
+
+	if s.Wait(waiting, nil) {
+		t.Error("waiting ticket is not cancelled after close")
+	}
+	if s.Wait(s.Enqueue(&Ticket{Class: "video-url", JobID: 3, UserID: 3}), nil) {
+		t.Error("new job is accepted after close")
+	}
+
+	convert := s.Enqueue(&Ticket{Class: "convert", JobID: 1, UserID: 1})
+	if !s.Wait(convert, nil) {
+		t.Error("ticket of the running job is cancelled after close")
+	}
+
+	s.Release(convert)
+	s.Release(running)
+	if s.Len() != 0 {
+		t.Errorf("error scheduler len - %d", s.Len())
+	}
+}
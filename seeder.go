@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/dustin/go-humanize"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Seeder - the torrents after the jobs are seeded until the ratio or the time is reached,
+// the oldest ones are evicted when the seeded data is bigger the disk budget
+type Seeder struct {
+	App *App
+
+	mu       sync.Mutex
+	torrents map[metainfo.Hash]*seedEntry
+}
+
+// seedTorrent - the torrent of the client, the rules are checked without the client in the tests
+type seedTorrent interface {
+	InfoHash() metainfo.Hash
+	Info() *metainfo.Info
+	BytesCompleted() int64
+	Stats() torrent.TorrentStats
+	Drop()
+}
+
+type seedEntry struct {
+	torrent seedTorrent
+	// active - jobs using the torrent, since - the start of the seeding, zero while it is used
+	active int
+	since  time.Time
+}
+
+func NewSeeder(a *App) *Seeder {
+	return &Seeder{App: a, torrents: map[metainfo.Hash]*seedEntry{}}
+}
+
+// Acquire - the torrent is used by the job, it isn't evicted
+func (s *Seeder) Acquire(t *torrent.Torrent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.torrents[t.InfoHash()]
+	if !ok {
+		e = &seedEntry{torrent: t}
+		s.torrents[t.InfoHash()] = e
+	}
+	e.active++
+	e.since = time.Time{}
+
+	SaveTorrentMeta(t)
+	TouchTorrentData(t)
+}
+
+// Release - the job is finished, the torrent is seeded or dropped at once if the upload is off
+func (s *Seeder) Release(t *torrent.Torrent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.torrents[t.InfoHash()]
+	if !ok {
+		return
+	}
+	e.active--
+	if e.active > 0 {
+		return
+	}
+	TouchTorrentData(t)
+
+	if config.UploadLimit <= 0 {
+		s.evict(e, "upload is off")
+		return
+	}
+	e.since = time.Now()
+}
+
+// Shared - the torrent is used by another job or picker too
+func (s *Seeder) Shared(t *torrent.Torrent) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.torrents[t.InfoHash()]
+
+	return ok && e.active > 1
+}
+
+// Keep - folders of the torrent data which are used or seeded, the cleaner skips them
+func (s *Seeder) Keep() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := map[string]bool{}
+	for _, e := range s.torrents {
+		if info := e.torrent.Info(); info != nil {
+			keep[info.Name] = true
+		}
+	}
+
+	return keep
+}
+
+// Run checks the seeding rules every minute and sends the stats to the log channel every hour
+func (s *Seeder) Run(ctx context.Context) {
+	check := time.NewTicker(time.Minute)
+	defer check.Stop()
+	report := time.NewTicker(time.Hour)
+	defer report.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-check.C:
+			s.check()
+		case <-report.C:
+			s.App.SendLogToChannel(&tgbotapi.User{UserName: "seeder"}, "mess", s.Stats())
+		}
+	}
+}
+
+func (s *Seeder) check() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var seeding []*seedEntry
+	for _, e := range s.torrents {
+		if e.active > 0 {
+			continue
+		}
+
+		switch {
+		case seedRatio(e.torrent) >= config.SeedRatio:
+			s.evict(e, fmt.Sprintf("ratio %.2f", seedRatio(e.torrent)))
+		case time.Since(e.since) >= config.SeedTime:
+			s.evict(e, fmt.Sprintf("seeded %s, ratio %.2f", config.SeedTime, seedRatio(e.torrent)))
+		default:
+			seeding = append(seeding, e)
+		}
+	}
+
+	// the disk budget, the oldest seeding is evicted first
+	sort.Slice(seeding, func(i, j int) bool {
+		return seeding[i].since.Before(seeding[j].since)
+	})
+	var size int64
+	for _, e := range seeding {
+		size += e.torrent.BytesCompleted()
+	}
+	for _, e := range seeding {
+		if size <= config.SeedDiskBudget {
+			break
+		}
+		size -= e.torrent.BytesCompleted()
+		s.evict(e, fmt.Sprintf("disk budget %s, ratio %.2f",
+			humanize.Bytes(uint64(config.SeedDiskBudget)), seedRatio(e.torrent)))
+	}
+}
+
+// evict drops the torrent, its data stays in the cache of the torrents until the trim,
+// the lock is held by the caller
+func (s *Seeder) evict(e *seedEntry, reason string) {
+	delete(s.torrents, e.torrent.InfoHash())
+
+	var name string
+	if info := e.torrent.Info(); info != nil {
+		name = info.Name
+	}
+	stats := e.torrent.Stats()
+	e.torrent.Drop()
+
+	if config.UploadLimit > 0 {
+		s.App.SendLogToChannel(&tgbotapi.User{UserName: "seeder"}, "mess",
+			fmt.Sprintf("torrent evicted - %s, %s, uploaded %s", name, reason,
+				humanize.Bytes(uint64(stats.BytesWrittenData.Int64()))))
+	}
+}
+
+// Stats - uploaded and downloaded bytes of the client and the seeding torrents
+func (s *Seeder) Stats() string {
+	cs := s.App.TorClient.ConnStats()
+	uploaded := cs.BytesWrittenData.Int64()
+	downloaded := cs.BytesReadData.Int64()
+
+	var ratio float64
+	if downloaded > 0 {
+		ratio = float64(uploaded) / float64(downloaded)
+	}
+
+	s.mu.Lock()
+	var (
+		seeding int
+		size    int64
+	)
+	for _, e := range s.torrents {
+		if e.active == 0 {
+			seeding++
+			size += e.torrent.BytesCompleted()
+		}
+	}
+	s.mu.Unlock()
+
+	return fmt.Sprintf("📊 torrent client - uploaded %s, downloaded %s, ratio %.2f, seeding %d torrents, %s on disk",
+		humanize.Bytes(uint64(uploaded)), humanize.Bytes(uint64(downloaded)), ratio, seeding,
+		humanize.Bytes(uint64(size)))
+}
+
+// seedRatio - uploaded to the downloaded data of the torrent
+func seedRatio(t seedTorrent) float64 {
+	completed := t.BytesCompleted()
+	if completed <= 0 {
+		return 0
+	}
+
+	stats := t.Stats()
+
+	return float64(stats.BytesWrittenData.Int64()) / float64(completed)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"testing"
+	"time"
+)
+
+type fakeTorrent struct {
+	hash      metainfo.Hash
+	completed int64
+	uploaded  int64
+	dropped   bool
+}
+
+func (f *fakeTorrent) InfoHash() metainfo.Hash { return f.hash }
+func (f *fakeTorrent) Info() *metainfo.Info    { return nil }
+func (f *fakeTorrent) BytesCompleted() int64   { return f.completed }
+func (f *fakeTorrent) Drop()                   { f.dropped = true }
+
+func (f *fakeTorrent) Stats() torrent.TorrentStats {
+	var stats torrent.TorrentStats
+	stats.BytesWrittenData.Add(f.uploaded)
+
+	return stats
+}
+
+func TestSeederCheck(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.UploadLimit = 0
+	config.SeedRatio = 2
+	config.SeedTime = time.Hour
+	config.SeedDiskBudget = 100
+
+	s := NewSeeder(nil)
+	add := func(b byte, completed int64, uploaded int64, active int, seeded time.Duration) *fakeTorrent {
+		f := &fakeTorrent{hash: metainfo.Hash{b}, completed: completed, uploaded: uploaded}
+		e := &seedEntry{torrent: f, active: active}
+		if active == 0 {
+			e.since = time.Now().Add(-seeded)
+		}
+		s.torrents[f.hash] = e
+		return f
+	}
+
+	used := add(1, 500, 5000, 1, 0)
+	ratio := add(2, 10, 20, 0, time.Minute)
+	old := add(3, 10, 0, 0, 2*time.Hour)
+	// 120 bytes are seeded, the oldest one is evicted till the budget
+	oldest := add(4, 40, 0, 0, 30*time.Minute)
+	older := add(5, 40, 0, 0, 20*time.Minute)
+	newer := add(6, 40, 0, 0, 10*time.Minute)
+
+	s.check()
+
+	for name, c := range map[string]struct {
+		f       *fakeTorrent
+		dropped bool
+	}{
+		"used":   {used, false},
+		"ratio":  {ratio, true},
+		"time":   {old, true},
+		"oldest": {oldest, true},
+		"older":  {older, false},
+		"newer":  {newer, false},
+	} {
+		if c.f.dropped != c.dropped {
+			t.Errorf("%s - dropped %v, want %v", name, c.f.dropped, c.dropped)
+		}
+		if _, kept := s.torrents[c.f.hash]; kept == c.dropped {
+			t.Errorf("%s - kept %v", name, kept)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	CaptionFull  = "full"
+	CaptionShort = "short"
+	CaptionNone  = "none"
+)
+
+// the values of the menu, a button switches to the next one
+var (
+	settingsQualities = []int{0, VideoQualityAuto, 2160, 1440, 1080, 720, 480, 360}
+	settingsLanguages = []string{"", "en", "ru"}
+	settingsCaptions  = []string{CaptionFull, CaptionShort, CaptionNone}
+	captionLabels     = map[string]string{
+		CaptionFull:  "Name and url",
+		CaptionShort: "Name only",
+		CaptionNone:  "No caption",
+	}
+)
+
+// UserSettings - defaults of the tasks of the user, the flags of the message override them
+type UserSettings struct {
+	TelegramID int64 `db:"telegram_id"`
+	// VideoQuality - max height of the video by default, 0 - the picker is shown, -1 - the best one
+	VideoQuality int `db:"video_quality"`
+	// AudioOnly - only the audio of the video url is sent, +video overrides it
+	AudioOnly bool `db:"audio_only"`
+	// Language - empty is the language of the telegram
+	Language string `db:"language"`
+	Caption  string `db:"caption"`
+	// Protect - the sent files can't be forwarded or saved
+	Protect bool `db:"protect"`
+	// Notify - the sent files come with the sound
+	Notify bool `db:"notify"`
+}
+
+func DefaultSettings(telegramID int64) UserSettings {
+	return UserSettings{TelegramID: telegramID, Caption: CaptionFull, Notify: true}
+}
+
+// LoadSettings - the settings of the user or the default ones if nothing is changed
+func LoadSettings(telegramID int64) UserSettings {
+	s := DefaultSettings(telegramID)
+	err := Postgres.Get(&s, `SELECT telegram_id, video_quality, audio_only, language, caption, protect, notify
+		FROM user_settings WHERE telegram_id = $1`, telegramID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error(err)
+	}
+
+	return s
+}
+
+func (s UserSettings) Save() error {
+	_, err := Postgres.Exec(`INSERT INTO user_settings
+		(telegram_id, video_quality, audio_only, language, caption, protect, notify, date_update)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (telegram_id) DO UPDATE SET video_quality = $2, audio_only = $3, language = $4,
+			caption = $5, protect = $6, notify = $7, date_update = $8`,
+		s.TelegramID, s.VideoQuality, s.AudioOnly, s.Language, s.Caption, s.Protect, s.Notify, time.Now())
+
+	return err
+}
+
+// Switch - the button of the menu, false if the key is unknown
+func (s *UserSettings) Switch(key string) bool {
+	switch key {
+	case "q":
+		s.VideoQuality = nextOf(settingsQualities, s.VideoQuality)
+	case "m":
+		s.AudioOnly = !s.AudioOnly
+	case "l":
+		s.Language = nextOf(settingsLanguages, s.Language)
+	case "c":
+		s.Caption = nextOf(settingsCaptions, s.Caption)
+	case "p":
+		s.Protect = !s.Protect
+	case "n":
+		s.Notify = !s.Notify
+	default:
+		return false
+	}
+
+	return true
+}
+
+// nextOf - the value after the current one, the first one if the current one isn't in the list
+func nextOf[T comparable](list []T, cur T) T {
+	for i, val := range list {
+		if val == cur {
+			return list[(i+1)%len(list)]
+		}
+	}
+
+	return list[0]
+}
+
+// Translate - the language of the settings, else the language of the telegram
+func (s UserSettings) Translate(code string) *Translate {
+	if s.Language != "" {
+		code = s.Language
+	}
+
+	return &Translate{Code: code}
+}
+
+// Caption - the caption of the sent file in the style of the user, the text is the name and the url
+func (t *Task) Caption(text string) string {
+	switch t.Settings.Caption {
+	case CaptionShort:
+		name, _, _ := strings.Cut(text, "\n")
+		return name + signAdvt
+	case CaptionNone:
+		return strings.TrimPrefix(signAdvt, "\n\n")
+	}
+
+	return text + signAdvt
+}
+
+// audioCaption - the audios of the album have the names, the last one has the url too
+func (t *Task) audioCaption(name string, last bool) string {
+	if last {
+		if t.DescriptionUrl != "" {
+			name += "\n" + t.DescriptionUrl
+		}
+		return t.Caption(name)
+	}
+	if t.Settings.Caption == CaptionNone {
+		return ""
+	}
+
+	return name
+}
+
+// Deliver - the forward protection and the notification of the sent file
+func (t *Task) Deliver(chat *tgbotapi.BaseChat, forwardLock bool) {
+	chat.ProtectContent = forwardLock || t.Settings.Protect
+	chat.DisableNotification = !t.Settings.Notify
+}
+
+func (s UserSettings) Render(tr *Translate) (string, tgbotapi.InlineKeyboardMarkup) {
+	onOff := func(on bool) string {
+		if on {
+			return "✅"
+		}
+		return "☑️"
+	}
+
+	quality := tr.Lang("Ask")
+	switch {
+	case s.VideoQuality == VideoQualityAuto:
+		quality = tr.Lang("Auto")
+	case s.VideoQuality > 0:
+		quality = fmt.Sprintf("%dp", s.VideoQuality)
+	}
+	mode := "🎬 " + tr.Lang("Video")
+	if s.AudioOnly {
+		mode = "🎵 " + tr.Lang("Audio only")
+	}
+	language := tr.Lang("Telegram")
+	if s.Language != "" {
+		language = s.Language
+	}
+
+	text := "⚙️ " + tr.Lang("Settings") + "\n\n" +
+		tr.Lang("The flags of the message override the settings, for example +quality, +audio or +video")
+
+	row := func(label string, key string) []tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, "st:"+key))
+	}
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(
+		row("📺 "+tr.Lang("Quality")+": "+quality, "q"),
+		row(mode, "m"),
+		row("🌐 "+tr.Lang("Language")+": "+language, "l"),
+		row("📝 "+tr.Lang("Caption")+": "+tr.Lang(captionLabels[s.Caption]), "c"),
+		row(onOff(s.Protect)+" "+tr.Lang("Forward protection"), "p"),
+		row(onOff(s.Notify)+" "+tr.Lang("Notifications"), "n"),
+		row("✖️ "+tr.Lang("Close"), "x"),
+	)
+}
+
+// SendSettings - the /settings command
+func (a *App) SendSettings(message *tgbotapi.Message) {
+	s := LoadSettings(message.From.ID)
+
+	text, keyboard := s.Render(s.Translate(message.From.LanguageCode))
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = keyboard
+	if _, err := a.Bot.Send(msg); err != nil {
+		log.Warn(err)
+	}
+}
+
+// SettingsCallback - data is the key of the setting
+func (a *App) SettingsCallback(cq *tgbotapi.CallbackQuery, data string) string {
+	if data == "x" {
+		a.Bot.Send(tgbotapi.NewDeleteMessage(cq.Message.Chat.ID, cq.Message.MessageID))
+		return ""
+	}
+
+	s := LoadSettings(cq.From.ID)
+	if !s.Switch(data) {
+		return ""
+	}
+	tr := s.Translate(cq.From.LanguageCode)
+	if err := s.Save(); err != nil {
+		log.Error(err)
+		return tr.Lang("Something wrong... I will be fixing it")
+	}
+
+	text, keyboard := s.Render(tr)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(cq.Message.Chat.ID, cq.Message.MessageID, text, keyboard)
+	if _, err := a.Bot.Send(edit); err != nil {
+		log.Warn(err)
+	}
+
+	return tr.Lang("Saved")
+}
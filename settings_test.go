@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSettingsSwitch(t *testing.T) {
+	s := DefaultSettings(1)
+
+	var qualities []int
+	for range settingsQualities {
+		s.Switch("q")
+		qualities = append(qualities, s.VideoQuality)
+	}
+	if qualities[0] != VideoQualityAuto || qualities[len(qualities)-1] != 0 {
+		t.Errorf("qualities %v - the cycle must start after the picker and end with it", qualities)
+	}
+
+	s.Caption = "unknown"
+	s.Switch("c")
+	if s.Caption != CaptionFull {
+		t.Errorf("caption %s, want %s", s.Caption, CaptionFull)
+	}
+
+	if !s.Switch("n") || s.Notify {
+		t.Error("notifications are not switched off")
+	}
+	if s.Switch("z") {
+		t.Error("unknown key is switched")
+	}
+}
+
+func TestTaskCaption(t *testing.T) {
+	task := &Task{}
+	for style, want := range map[string]string{
+		CaptionFull:  "name\nhttps://video.url" + signAdvt,
+		CaptionShort: "name" + signAdvt,
+		CaptionNone:  signAdvt[2:],
+	} {
+		task.Settings.Caption = style
+		if got := task.Caption("name\nhttps://video.url"); got != want {
+			t.Errorf("%s - %q, want %q", style, got, want)
+		}
+	}
+}
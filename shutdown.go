@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Updates passes the bot updates through until ctx is done, then stops receiving them
+func (a *App) Updates(ctx context.Context) <-chan tgbotapi.Update {
+	ch := make(chan tgbotapi.Update)
+
+	go func() {
+		defer close(ch)
+		defer a.Bot.StopReceivingUpdates()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-a.BotUpdates:
+				if !ok {
+					return
+				}
+				select {
+				case ch <- update:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Shutdown waits for the running tasks until the timeout, the rest is killed and resumed after restart,
+// returns exit status: 0 - all tasks finished, 1 - tasks were interrupted
+func (a *App) Shutdown(timeout time.Duration) int {
+	log.Infof("Shutting down, waiting for tasks up to %s", timeout)
+
+	a.draining.Store(true)
+	a.Scheduler.Close()
+
+	a.Tasks.Range(func(_, val any) bool {
+		task := val.(*Task)
+		if task.Stopped() {
+			return true
+		}
+
+		mess := tgbotapi.NewMessage(task.Message.Chat.ID, "🔄 "+task.Lang(
+			"The bot is restarting, if your task doesn't finish in time, it will be resumed after the restart"))
+		mess.ReplyToMessageID = task.Message.MessageID
+		mess.AllowSendingWithoutReply = true
+		task.Send(mess)
+
+		return true
+	})
+
+	status := 0
+	if !a.waitTasks(timeout) {
+		log.Warn("Shutdown timeout, killing tasks")
+		status = 1
+
+		// subprocesses die with the contexts of the tasks
+		a.stop(ErrShutdown)
+		if !a.waitTasks(10 * time.Second) {
+			log.Error("Tasks didn't stop after kill")
+		}
+	}
+	a.stop(ErrShutdown)
+
+	a.Stream.Close()
+	for _, err := range a.TorClient.Close() {
+		log.Error(err)
+	}
+	if err := Postgres.Close(); err != nil {
+		log.Error(err)
+	}
+
+	log.Infof("TorPurrBot is stopped, status %d", status)
+
+	return status
+}
+
+func (a *App) waitTasks(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		a.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
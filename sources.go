@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Source - kind of links the bot downloads. The router, the "Not allowed url" message and /info
+// are generated from the registered sources, a new source only needs RegisterSource
+type Source struct {
+	// Name is the source type of the job
+	Name string
+	// Class of the scheduler workers and of the daily limits
+	Class string
+	// DailyLimit - tasks per 24 hours for free users
+	DailyLimit int
+	// Hosts are shown in the "Not allowed url" message
+	Hosts    []string
+	Examples []SourceExample
+	Match    func(t *Task) bool
+	New      func(t *Task) ObjectHandler
+}
+
+type SourceExample struct {
+	Title string
+	Url   string
+}
+
+var sources []*Source
+
+// RegisterSource adds the source to the router, the first matched source wins
+func RegisterSource(s *Source) {
+	sources = append(sources, s)
+}
+
+func MatchSource(t *Task) *Source {
+	for _, s := range sources {
+		if s.Match(t) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// SupportedHosts - the list for the "Not allowed url" message
+func SupportedHosts() string {
+	var hosts []string
+	for _, s := range sources {
+		hosts = append(hosts, s.Hosts...)
+	}
+
+	return strings.Join(hosts, "\n")
+}
+
+// SourceExamples - the examples for /info
+func SourceExamples(tr *Translate) string {
+	var examples string
+	for _, s := range sources {
+		for _, ex := range s.Examples {
+			examples += tr.Lang("Example") + " " + ex.Title + ":\n " + ex.Url + "\n"
+		}
+	}
+
+	return examples
+}
+
+func containsAny(text string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(text, sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var videoUrlHosts = []string{
+	"youtube.com",
+	"youtu.be",
+	"tiktok.com",
+	"vk.com/video",
+	"twitch.tv/videos",
+	"twitch.tv/*****/clip",
+	"rutube.ru/video",
+	"coub.com/view",
+}
+
+func init() {
+	RegisterSource(&Source{
+		Name:       "torrent",
+		Class:      "torrent",
+		DailyLimit: 2,
+		// the torrent process is added when the file or the magnet link is received
+		Match: func(t *Task) bool {
+			return t.Torrent.Process != nil
+		},
+		New: func(t *Task) ObjectHandler {
+			return &ObjectTorrent{Task: t, TorrentProcess: t.Torrent.Process}
+		},
+	})
+
+	RegisterSource(&Source{
+		Name:       "spotify",
+		Class:      "spotify",
+		DailyLimit: 5,
+		Hosts:      []string{"open.spotify.com/track", "open.spotify.com/album"},
+		Examples: []SourceExample{
+			{"Spotify track", "https://open.spotify.com/track/1hEh8Hc9lBAFWUghHBsCel"},
+			{"Spotify album", "https://open.spotify.com/album/1YxUJdI0JWsXGGq8xa1SLt"},
+		},
+		Match: func(t *Task) bool {
+			return strings.Contains(t.Message.Text, "https://open.spotify.com/track/") ||
+				strings.Contains(t.Message.Text, "https://open.spotify.com/album")
+		},
+		New: func(t *Task) ObjectHandler {
+			return &ObjectSpotify{Task: t}
+		},
+	})
+
+	RegisterSource(&Source{
+		Name:       "video-url",
+		Class:      "video-url",
+		DailyLimit: 5,
+		Hosts:      videoUrlHosts,
+		Examples: []SourceExample{
+			{"YouTube", "https://www.youtube.com/watch?v=XqwbqxzsA2g"},
+			{"TikTok", "https://vt.tiktok.com/ZS8EYpxHP"},
+			{"VK Video", "https://vk.com/video-118281792_456242739"},
+			{"Twitch Clip", "https://www.twitch.tv/guhrl/clip/CrowdedCrowdedClintCharlietheUnicorn-igG_XEcFiBw2KoVX"},
+			{"RuTube Video", "https://rutube.ru/video/37b5e31d214ee0496e380a028c279c36"},
+			{"Coub", "https://coub.com/view/3bfclw"},
+		},
+		Match: func(t *Task) bool {
+			text := t.Message.Text
+			if !strings.HasPrefix(text, "https://") {
+				return false
+			}
+			if strings.Contains(text, "twitch.tv") && strings.Contains(text, "/clip") {
+				return true
+			}
+
+			// instagram works with cookies only, it isn't advertised
+			return containsAny(text, videoUrlHosts) || strings.Contains(text, "instagram.com/reel")
+		},
+		New: func(t *Task) ObjectHandler {
+			return &ObjectVideoUrl{Task: t}
+		},
+	})
+
+	// the rest of the links to files, checked by HEAD whether it is a file
+	RegisterSource(&Source{
+		Name:       "direct-url",
+		Class:      "direct-url",
+		DailyLimit: 5,
+		Hosts:      []string{"direct links to files"},
+		Match: func(t *Task) bool {
+			return IsFileUrl(t.Options().Url)
+		},
+		New: func(t *Task) ObjectHandler {
+			return &ObjectDirectUrl{Task: t}
+		},
+	})
+}
+
+// webPageExts - the links of the pages, not of the files
+var webPageExts = map[string]bool{".html": true, ".htm": true, ".php": true, ".asp": true, ".aspx": true,
+	".jsp": true}
+
+// IsFileUrl - the https link with the name of the file at the end of the path, the rest of the links
+// get "Not allowed url" without the job
+func IsFileUrl(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return false
+	}
+
+	ext := strings.ToLower(path.Ext(u.Path))
+
+	return ext != "" && !webPageExts[ext]
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/anacrolix/torrent"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	"testing"
+)
+
+func TestMatchSource(t *testing.T) {
+	cases := map[string]string{
+		"https://www.youtube.com/watch?v=XqwbqxzsA2g":                  "video-url",
+		"https://www.twitch.tv/guhrl/clip/CrowdedCrowdedClint":         "video-url",
+		"https://www.instagram.com/reel/Cp1/":                          "video-url",
+		"https://open.spotify.com/track/1hEh8Hc9lBAFWUghHBsCel":        "spotify",
+		"https://open.spotify.com/album/1YxUJdI0JWsXGGq8xa1SLt":        "spotify",
+		"listen https://open.spotify.com/track/1hEh8Hc9lBAFWUghHBsCel": "spotify",
+		"https://example.com/file.mp4":                                 "direct-url",
+		"https://example.com/dl/Archive.ZIP?token=1":                   "direct-url",
+		"https://example.com/news/page":                                "",
+		"https://example.com/index.html":                               "",
+		"https://example.com/":                                         "",
+		"hello":                                                        "",
+		"http://www.youtube.com/watch?v=XqwbqxzsA2g without https":     "",
+	}
+
+	for text, want := range cases {
+		task := &Task{Message: &tgbotapi.Message{Text: text}}
+
+		var got string
+		if s := MatchSource(task); s != nil {
+			got = s.Name
+		}
+		if got != want {
+			t.Errorf("%s - source %q, want %q", text, got, want)
+		}
+	}
+
+	task := &Task{Message: &tgbotapi.Message{Text: "file.mkv ~ 700 MB"}}
+	task.Torrent.Process = &torrent.Torrent{}
+	if s := MatchSource(task); s == nil || s.Name != "torrent" {
+		t.Error("torrent choice is not matched")
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// telegramMaxFileSize - the limit of the bot api server for one file
+	telegramMaxFileSize = 1999e6
+	// splitPartSize - the target size of a part, video segments are cut at keyframes and may be bigger
+	splitPartSize = 1900e6
+)
+
+// Split - files bigger 2 GB are sent in parts, the mode is turned on by the +split flag.
+// Videos are cut by ffmpeg into playable segments, other files are packed into a zip in volumes
+type Split struct {
+	Task *Task
+}
+
+func (t *Task) SplitEnabled() bool {
+	return t.Options().Split
+}
+
+// PartKey - the path of the part for the cache, the parts of the file are found by the native path
+func PartKey(nativePath string, part int, parts int) string {
+	return fmt.Sprintf("%s.part%03dof%03d", nativePath, part, parts)
+}
+
+// ParsePartKey - number of the part and count of the parts, ok is false for a not part
+func ParsePartKey(nativePath string, key string) (part int, parts int, ok bool) {
+	rest, found := strings.CutPrefix(key, nativePath+".part")
+	if !found {
+		return 0, 0, false
+	}
+
+	partStr, partsStr, found := strings.Cut(rest, "of")
+	if !found {
+		return 0, 0, false
+	}
+
+	part, err := strconv.Atoi(partStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	parts, err = strconv.Atoi(partsStr)
+	if err != nil || part < 1 || part > parts {
+		return 0, 0, false
+	}
+
+	return part, parts, true
+}
+
+func (t *Task) PartCaption(part int, parts int) string {
+	return fmt.Sprintf(t.Lang("part %d of %d"), part, parts)
+}
+
+func (s Split) folder() (string, error) {
+	folder := s.Task.Workspace + "/" + s.Task.UniqueId("files-split")
+	if err := os.Mkdir(folder, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	return folder, nil
+}
+
+// Video cuts the video without re-encoding, the cut is retried with shorter segments
+// if a segment is bigger the limit, long keyframe intervals make segments uneven
+func (s Split) Video(file FileConverted) ([]FileConverted, error) {
+	c := Convert{Task: s.Task}
+
+	fileInfo, err := os.Stat(file.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := strconv.ParseFloat(c.GetInfoVideo(file.FilePath).Format.Duration, 64)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("duration of %s is unknown", file.FilePath)
+	}
+
+	folder, err := s.folder()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := math.Ceil(float64(fileInfo.Size()) / splitPartSize)
+	segmentTime := duration / parts * 0.95
+
+	for attempt := 0; attempt < 3; attempt++ {
+		files, err := s.cutVideo(file.FilePath, folder+"/"+file.Name, segmentTime)
+		if err != nil {
+			return nil, err
+		}
+
+		var tooBig bool
+		for _, val := range files {
+			if info, err := os.Stat(val); err != nil || info.Size() > telegramMaxFileSize {
+				tooBig = true
+			}
+		}
+
+		if !tooBig {
+			var converted []FileConverted
+			for _, val := range files {
+				// the cover of the whole video is fine for every part
+				converted = append(converted, FileConverted{
+					Name:           file.Name,
+					FilePath:       val,
+					FilePathNative: file.FilePathNative,
+					CoverPath:      file.CoverPath,
+					CoverSize:      file.CoverSize,
+				})
+			}
+
+			return converted, nil
+		}
+
+		for _, val := range files {
+			_ = os.Remove(val)
+		}
+		segmentTime *= 0.8
+	}
+
+	return nil, fmt.Errorf("segments of %s are bigger 2 GB", file.FilePath)
+}
+
+func (s Split) cutVideo(filePath string, prefix string, segmentTime float64) ([]string, error) {
+	ffmpegPath := "./ffmpeg"
+	if config.IsDev {
+		ffmpegPath = "ffmpeg"
+	}
+
+	out, err := exec.CommandContext(s.Task.Ctx, ffmpegPath,
+		"-protocol_whitelist", "file",
+		"-v", "error",
+		"-i", filePath,
+		"-map", "0:v:0", "-map", "0:a?",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(segmentTime, 'f', 0, 64),
+		"-reset_timestamps", "1",
+		// the options of the mp4 muxer of every part, moov goes first for the streaming
+		"-segment_format", "mp4",
+		"-segment_format_options", "movflags=+faststart",
+		"-y",
+		prefix+".part%03d.mp4").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, out)
+	}
+
+	files, err := filepath.Glob(escapeGlob(prefix) + ".part[0-9][0-9][0-9].mp4")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no segments of %s", filePath)
+	}
+
+	return files, nil
+}
+
+// Archive packs the file into a zip without compression and cuts the zip into volumes
+// name.zip.001, name.zip.002..., 7-Zip opens the first volume, or cat joins them back
+func (s Split) Archive(filePath string) ([]string, error) {
+	return s.pack(path.Base(filePath)+".zip", []zipEntry{{Path: filePath, Name: path.Base(filePath)}}, true)
+}
+
+type zipEntry struct {
+	// Path on the disk, Name in the archive
+	Path string
+	Name string
+}
+
+// Zip streams the files into the archive, the archive bigger 2 GB is cut into volumes
+func (s Split) Zip(name string, entries []zipEntry) ([]string, error) {
+	volumes, err := s.pack(name, entries, false)
+	if err != nil || len(volumes) != 1 {
+		return volumes, err
+	}
+
+	// the only volume is the whole archive
+	single := strings.TrimSuffix(volumes[0], ".001")
+	if err := os.Rename(volumes[0], single); err != nil {
+		return nil, err
+	}
+
+	return []string{single}, nil
+}
+
+func (s Split) pack(name string, entries []zipEntry, store bool) ([]string, error) {
+	folder, err := s.folder()
+	if err != nil {
+		return nil, err
+	}
+
+	vw := &volumeWriter{Prefix: folder + "/" + name, Size: splitPartSize}
+	defer vw.Close()
+
+	zw := zip.NewWriter(vw)
+	for _, entry := range entries {
+		if err := s.packFile(zw, entry, store); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := vw.Close(); err != nil {
+		return nil, err
+	}
+
+	return vw.Files, nil
+}
+
+func (s Split) packFile(zw *zip.Writer, entry zipEntry, store bool) error {
+	src, err := os.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.Name
+	header.Method = zip.Store
+	if !store && zipDeflate(entry.Name) {
+		header.Method = zip.Deflate
+	}
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, &ctxReader{Task: s.Task, Reader: src})
+
+	return err
+}
+
+// zipDeflate - text is compressed, media is already compressed and only stored
+func zipDeflate(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".txt", ".nfo", ".srt", ".ass", ".ssa", ".sub", ".vtt", ".cue", ".log", ".m3u", ".m3u8",
+		".json", ".xml", ".html", ".htm", ".md", ".csv", ".ini", ".sfv", ".md5":
+		return true
+	}
+
+	return false
+}
+
+// volumeWriter - a stream cut into files of the size
+type volumeWriter struct {
+	Prefix string
+	Size   int64
+	Files  []string
+
+	file    *os.File
+	written int64
+}
+
+func (v *volumeWriter) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		if v.file == nil || v.written >= v.Size {
+			if err := v.next(); err != nil {
+				return total, err
+			}
+		}
+
+		chunk := p
+		if rest := v.Size - v.written; int64(len(chunk)) > rest {
+			chunk = chunk[:rest]
+		}
+
+		n, err := v.file.Write(chunk)
+		total += n
+		v.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+
+	return total, nil
+}
+
+func (v *volumeWriter) next() error {
+	if err := v.Close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.%03d", v.Prefix, len(v.Files)+1)
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	v.file = file
+	v.written = 0
+	v.Files = append(v.Files, name)
+
+	return nil
+}
+
+func (v *volumeWriter) Close() error {
+	if v.file == nil {
+		return nil
+	}
+
+	err := v.file.Close()
+	v.file = nil
+
+	return err
+}
+
+// ctxReader stops the copy when the task is stopped
+type ctxReader struct {
+	Task   *Task
+	Reader io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if r.Task.Stopped() {
+		return 0, r.Task.StopError()
+	}
+
+	return r.Reader.Read(p)
+}
+
+func escapeGlob(pattern string) string {
+	return strings.NewReplacer("*", "\\*", "?", "\\?", "[", "\\[", "\\", "\\\\").Replace(pattern)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartKey(t *testing.T) {
+	native := "/bot/torrent-client/Show/s01e01.mkv"
+
+	key := PartKey(native, 2, 3)
+	part, parts, ok := ParsePartKey(native, key)
+	if !ok || part != 2 || parts != 3 {
+		t.Fatalf("%s - part %d of %d, ok %v", key, part, parts, ok)
+	}
+
+	for _, key := range []string{
+		native,
+		"/bot/torrent-client/Show/s01e02.mkv.part001of002",
+		native + ".part004of003",
+		native + ".partXof3",
+	} {
+		if _, _, ok := ParsePartKey(native, key); ok {
+			t.Errorf("%s is parsed as a part", key)
+		}
+	}
+}
+
+func TestVolumeWriter(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 25)
+
+	vw := &volumeWriter{Prefix: filepath.Join(dir, "file.zip"), Size: 100}
+	// uneven writes cross the volume borders
+	for _, chunk := range [][]byte{data[:30], data[30:170], data[170:]} {
+		if _, err := vw.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vw.Files) != 3 {
+		t.Fatalf("volumes %d, want 3", len(vw.Files))
+	}
+
+	var joined []byte
+	for i, name := range vw.Files {
+		volume, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && len(volume) != 100 {
+			t.Errorf("%s - size %d, want 100", name, len(volume))
+		}
+		joined = append(joined, volume...)
+	}
+
+	if !bytes.Equal(joined, data) {
+		t.Error("joined volumes differ from the data")
+	}
+}
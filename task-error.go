@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrSentFromCache - the file is sent from the cache, the rest of the steps is skipped
+var ErrSentFromCache = errors.New("sent from cache")
+
+// TaskError - failure of a task step.
+// Key is the translate key of the message for the user and the fail reason of the job,
+// Hint is appended to the message as is, Detail and Err go to the logs only
+type TaskError struct {
+	Key       string
+	Hint      string
+	Retryable bool
+	Detail    string
+	Err       error
+}
+
+func (e *TaskError) Error() string {
+	msg := e.Key
+	if e.Detail != "" {
+		msg += " - " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// AsTaskError - the task error from the chain, unknown errors become "Something wrong"
+func AsTaskError(err error) *TaskError {
+	var te *TaskError
+	if errors.As(err, &te) {
+		return te
+	}
+
+	return &TaskError{Key: "Something wrong... I will be fixing it", Retryable: true, Err: err}
+}
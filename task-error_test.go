@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func TestAsTaskError(t *testing.T) {
+	te := &TaskError{Key: "Video url is bad", Retryable: true, Err: errors.New("exit status 1")}
+
+	got := AsTaskError(errors.Wrap(te, "download"))
+	if got != te {
+		t.Errorf("wrapped task error is lost - %v", got)
+	}
+
+	got = AsTaskError(errors.New("boom"))
+	if got.Key != "Something wrong... I will be fixing it" || got.Err == nil {
+		t.Errorf("unknown error - %+v", got)
+	}
+
+	if !errors.Is(te, te.Err) {
+		t.Error("task error doesn't unwrap")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// ChatTasks - tasks of the chat in the order they were created
+func (a *App) ChatTasks(chatID int64) []*Task {
+	var tasks []*Task
+	a.Tasks.Range(func(_, val any) bool {
+		if task := val.(*Task); task.Message.Chat.ID == chatID {
+			tasks = append(tasks, task)
+		}
+		return true
+	})
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Job.ID < tasks[j].Job.ID
+	})
+
+	return tasks
+}
+
+func (a *App) TasksMessage(chatID int64, tr *Translate) (string, tgbotapi.InlineKeyboardMarkup) {
+	tasks := a.ChatTasks(chatID)
+	if len(tasks) == 0 {
+		return "📭 " + tr.Lang("You have no tasks"), tgbotapi.InlineKeyboardMarkup{}
+	}
+
+	text := "📋 " + tr.Lang("Your tasks") + "\n"
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, task := range tasks {
+		source := task.Job.Url
+		if task.Job.SourceType == "torrent" && task.Torrent.Process != nil {
+			source = fmt.Sprintf("%s, %s: %d", task.Torrent.Process.Name(), tr.Lang("files"),
+				len(strings.Split(task.Job.TorrentChoice, ",")))
+			if folder, ok := ParseTorrentZip(task.Job.TorrentChoice); ok {
+				source = fmt.Sprintf("%s, 🗜 /%s", task.Torrent.Process.Name(), folder)
+			}
+		}
+		if ids, ok := ParsePlaylistChoice(task.Job.FlagsText()); ok {
+			source = fmt.Sprintf("%s, %s: %d", task.Job.Url, tr.Lang("videos"), len(ids))
+		}
+
+		text += fmt.Sprintf("\n%d. #%d %s - %s", i+1, task.Job.ID, task.Job.SourceType, tr.Lang(task.Job.StateText()))
+
+		if position, _ := a.Scheduler.Position(task.Ticket); position > 0 {
+			text += fmt.Sprintf(", %s: %d", tr.Lang("Your queue"), position)
+		} else if progress := task.Progress(); progress > 0 {
+			text += fmt.Sprintf(" %.2f%%", progress)
+		}
+		if task.Stopped() {
+			text += " ⛔️"
+		}
+		text += "\n" + source + "\n"
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("❌ %s #%d", tr.Lang("Cancel"), task.Job.ID),
+				fmt.Sprintf("cancel:%d", task.Job.ID))))
+	}
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (a *App) SendTasks(chatID int64, tr *Translate) {
+	text, keyboard := a.TasksMessage(chatID, tr)
+
+	mess := tgbotapi.NewMessage(chatID, text)
+	mess.DisableWebPagePreview = true
+	if len(keyboard.InlineKeyboard) > 0 {
+		mess.ReplyMarkup = keyboard
+	}
+
+	if _, err := a.Bot.Send(mess); err != nil {
+		log.Warn(err)
+	}
+}
+
+func (a *App) RefreshTasks(chatID int64, messageID int, tr *Translate) {
+	text, keyboard := a.TasksMessage(chatID, tr)
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.DisableWebPagePreview = true
+	if len(keyboard.InlineKeyboard) > 0 {
+		edit.ReplyMarkup = &keyboard
+	}
+
+	if _, err := a.Bot.Send(edit); err != nil {
+		log.Warn(err)
+	}
+}
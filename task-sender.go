@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	tgbotapi "github.com/krol44/telegram-bot-api"
 	log "github.com/sirupsen/logrus"
@@ -12,26 +13,68 @@ import (
 
 const signAdvt = "\n\n@TorPurrBot - Download Torrent, YouTube, Spotify, TikTok, Other"
 
-func (t *Task) SendVideo(forwardLock bool) bool {
+func (t *Task) SendVideo(forwardLock bool) error {
 	file := t.FileConverted
 	if file.FilePath == "" {
-		return false
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "no converted file"}
 	}
 
 	fileInfo, err := os.Stat(file.FilePath)
 	if err != nil {
-		log.Error(err)
+		return &TaskError{Key: "Something wrong... I will be fixing it", Err: err}
 	}
-	if fileInfo.Size() > 1999e6 {
-		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "❗️ "+t.Lang("File is bigger 2 GB")))
-		t.App.SendLogToChannel(t.Message.From, "mess", "File is bigger 2 GB")
-		return false
+	if fileInfo.Size() > telegramMaxFileSize {
+		if t.SplitEnabled() {
+			return t.SendVideoParts(forwardLock)
+		}
+		return &TaskError{Key: "File is bigger 2 GB", Detail: file.Name}
+	}
+
+	sentVideo, err := t.sendVideoFile(file, "", forwardLock)
+	if err != nil {
+		return err
+	}
+
+	Cache.Add(Cache{Task: t}, sentVideo.Video.FileID, sentVideo.Video.FileSize, file.FilePathNative)
+
+	return nil
+}
+
+// SendVideoParts - the video bigger 2 GB is cut into segments, every segment is cached as the part
+func (t *Task) SendVideoParts(forwardLock bool) error {
+	t.Send(t.EditProgress(fmt.Sprintf("✂️ "+t.Lang("Cutting the video into parts")+" - %s",
+		t.FileConverted.Name)))
+
+	parts, err := Split{Task: t}.Video(t.FileConverted)
+	if err != nil {
+		if t.Stopped() {
+			return t.StopError()
+		}
+		return &TaskError{Key: "Video is bad", Detail: "split " + t.FileConverted.Name, Err: err}
+	}
+
+	for i, part := range parts {
+		sentVideo, err := t.sendVideoFile(part, t.PartCaption(i+1, len(parts)), forwardLock)
+		if err != nil {
+			return err
+		}
+
+		Cache.Add(Cache{Task: t}, sentVideo.Video.FileID, sentVideo.Video.FileSize,
+			PartKey(part.FilePathNative, i+1, len(parts)))
 	}
 
-	t.Send(tgbotapi.NewEditMessageText(t.Message.Chat.ID, t.MessageEditID,
-		fmt.Sprintf("📲 "+t.Lang("Sending video")+" - %s \n\n🍿 "+
-			t.Lang("Time upload to the telegram ~ 1-7 minutes"),
-			file.Name)))
+	return nil
+}
+
+func (t *Task) sendVideoFile(file FileConverted, part string, forwardLock bool) (tgbotapi.Message, error) {
+	name := file.Name
+	if part != "" {
+		name += " - " + part
+	}
+
+	t.Send(t.EditProgress(fmt.Sprintf("📲 "+t.Lang("Sending video")+" - %s \n\n🍿 "+
+		t.Lang("Time upload to the telegram ~ 1-7 minutes"),
+		name)))
 	t.App.SendLogToChannel(t.Message.From, "mess", "sending video")
 
 	video := tgbotapi.NewVideo(t.Message.Chat.ID,
@@ -43,132 +86,151 @@ func (t *Task) SendVideo(forwardLock bool) bool {
 	}
 
 	video.SupportsStreaming = true
-	video.Caption = file.Name + urlHttp + signAdvt
+	video.Caption = t.Caption(name + urlHttp)
 	video.Thumb = tgbotapi.FilePath(file.CoverPath)
 	video.Width = file.CoverSize.X
 	video.Height = file.CoverSize.Y
-	if forwardLock {
-		video.ProtectContent = true
+	t.Deliver(&video.BaseChat, forwardLock)
+
+	stopAction := t.ChatAction("upload_video")
+
+	sentVideo, err := t.App.Bot.Send(video)
+	stopAction()
+	if err != nil {
+		return sentVideo, &TaskError{Key: "Something wrong... I will be fixing it", Retryable: true,
+			Detail: "video file send", Err: err}
 	}
 
-	stopAction := false
-	go func(stopAction *bool) {
-		for {
-			if _, bo := t.App.ChatsWork.StopTasks.Load(t.Message.Chat.ID); bo {
-				return
-			}
+	var ist string
+	if t.Torrent.Name != "" {
+		ist = "☢️ torrent: "
+	}
 
-			if *stopAction == true {
-				return
-			}
+	t.App.SendLogToChannel(t.Message.From, "video", ist+"video file - "+name,
+		sentVideo.Video.FileID)
 
-			_, _ = t.App.Bot.Send(tgbotapi.NewChatAction(t.Message.Chat.ID, "upload_video"))
+	return sentVideo, nil
+}
 
-			time.Sleep(4 * time.Second)
+func (t *Task) SendDoc() error {
+	if t.File == "" {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "no file"}
+	}
+
+	fileInfo, err := os.Stat(t.File)
+	if err != nil {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Err: err}
+	}
+	if fileInfo.Size() > telegramMaxFileSize {
+		if t.SplitEnabled() {
+			return t.SendDocParts()
 		}
-	}(&stopAction)
+		return &TaskError{Key: "File is bigger 2 GB", Detail: t.File}
+	}
 
-	sentVideo, err := t.App.Bot.Send(video)
+	fileIDStr, fileSize, err := t.sendDocFile(t.File, "")
 	if err != nil {
-		stopAction = true
-		log.Error(err)
+		return err
+	}
 
-		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "😞 "+t.Lang("Something wrong... I will be fixing it")))
+	Cache.Add(Cache{Task: t}, fileIDStr, fileSize, t.File)
 
-		t.App.SendLogToChannel(t.Message.From, "mess",
-			fmt.Sprintf("video file send err\n\n%s", err))
-		return false
-	} else {
-		stopAction = true
+	return nil
+}
+
+// SendDocParts - the file bigger 2 GB is packed into volumes of a zip, every volume is cached as the part
+func (t *Task) SendDocParts() error {
+	t.Send(t.EditProgress(fmt.Sprintf("📦 "+t.Lang("Packing the file into parts")+" - %s",
+		path.Base(t.File))))
 
-		var ist string
-		if t.Torrent.Name != "" {
-			ist = "☢️ torrent: "
+	volumes, err := Split{Task: t}.Archive(t.File)
+	if err != nil {
+		if t.Stopped() {
+			return t.StopError()
 		}
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "split " + t.File, Err: err}
+	}
+
+	return t.SendDocVolumes(volumes, t.File)
+}
 
-		t.App.SendLogToChannel(t.Message.From, "video", ist+"video file - "+file.Name,
-			sentVideo.Video.FileID)
+// SendDocVolumes - volumes of the archive, every volume is cached as the part of the key,
+// the only volume is cached as the key itself
+func (t *Task) SendDocVolumes(volumes []string, cacheKey string) error {
+	if len(volumes) == 1 {
+		fileIDStr, fileSize, err := t.sendDocFile(volumes[0], "")
+		if err != nil {
+			return err
+		}
 
-		Cache.Add(Cache{Task: t}, sentVideo.Video.FileID, sentVideo.Video.FileSize, file.FilePathNative)
+		Cache.Add(Cache{Task: t}, fileIDStr, fileSize, cacheKey)
+		return nil
 	}
 
-	return true
-}
+	for i, volume := range volumes {
+		fileIDStr, fileSize, err := t.sendDocFile(volume, t.PartCaption(i+1, len(volumes)))
+		if err != nil {
+			return err
+		}
 
-func (t *Task) SendDoc() bool {
-	if t.File == "" {
-		return false
+		Cache.Add(Cache{Task: t}, fileIDStr, fileSize, PartKey(cacheKey, i+1, len(volumes)))
 	}
 
-	fileInfo, err := os.Stat(t.File)
-	if err != nil {
-		log.Error(err)
+	return nil
+}
+
+func (t *Task) sendDocFile(filePath string, part string) (string, int, error) {
+	name := t.Torrent.Name
+	if name == "" {
+		name = path.Base(t.File)
 	}
-	if fileInfo.Size() > 1999e6 {
-		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "❗️ "+t.Lang("File is bigger 2 GB")))
-		t.App.SendLogToChannel(t.Message.From, "mess", "File is bigger 2 GB")
-		return false
+	if part != "" {
+		name += " - " + part
 	}
 
-	t.Send(tgbotapi.NewEditMessageText(t.Message.Chat.ID, t.MessageEditID,
-		fmt.Sprintf("📲 "+t.Lang("Sending doc")+" - %s \n\n⏰ "+
-			t.Lang("Time upload to the telegram ~ 1-7 minutes"), t.Torrent.Name)))
+	t.Send(t.EditProgress(fmt.Sprintf("📲 "+t.Lang("Sending doc")+" - %s \n\n⏰ "+
+		t.Lang("Time upload to the telegram ~ 1-7 minutes"), name)))
 	t.App.SendLogToChannel(t.Message.From, "mess", "sending doc")
 
-	doc := tgbotapi.NewDocument(t.Message.Chat.ID, tgbotapi.FilePath(t.File))
-	doc.Caption = t.Torrent.Name + signAdvt
-
-	stopAction := false
-	go func(stopAction *bool) {
-		for {
-			if _, bo := t.App.ChatsWork.StopTasks.Load(t.Message.Chat.ID); bo {
-				return
-			}
-
-			if *stopAction == true {
-				return
-			}
+	var urlHttp string
+	if t.DescriptionUrl != "" {
+		urlHttp = "\n" + t.DescriptionUrl
+	}
 
-			_, _ = t.App.Bot.Send(tgbotapi.NewChatAction(t.Message.Chat.ID, "upload_document"))
+	doc := tgbotapi.NewDocument(t.Message.Chat.ID, tgbotapi.FilePath(filePath))
+	doc.Caption = t.Caption(name + urlHttp)
+	t.Deliver(&doc.BaseChat, false)
 
-			time.Sleep(4 * time.Second)
-		}
-	}(&stopAction)
+	stopAction := t.ChatAction("upload_document")
 
 	sentDoc, err := t.App.Bot.Send(doc)
+	stopAction()
 	if err != nil {
-		log.Error(err)
-
-		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "😞 "+t.Lang("Something wrong... I will be fixing it")))
+		return "", 0, &TaskError{Key: "Something wrong... I will be fixing it", Retryable: true,
+			Detail: "doc file send", Err: err}
+	}
 
-		t.App.SendLogToChannel(t.Message.From, "mess", fmt.Sprintf("send file err\n\n %s", err))
+	var (
+		fileIDStr string
+		fileSize  int
+	)
+	if sentDoc.Document != nil {
+		fileIDStr = sentDoc.Document.FileID
+		fileSize = sentDoc.Document.FileSize
 	} else {
-		var (
-			fileIDStr string
-			fileSize  int
-		)
-		if sentDoc.Document != nil {
-			fileIDStr = sentDoc.Document.FileID
-			fileSize = sentDoc.Document.FileSize
-		} else {
-			fileIDStr = sentDoc.Audio.FileID
-			fileSize = sentDoc.Audio.FileSize
-		}
-
-		var ist string
-		if t.Torrent.Name != "" {
-			ist = "☢️ torrent: "
-		}
-
-		t.App.SendLogToChannel(t.Message.From, "doc",
-			ist+"doc file - "+t.Torrent.Name, fileIDStr)
+		fileIDStr = sentDoc.Audio.FileID
+		fileSize = sentDoc.Audio.FileSize
+	}
 
-		Cache.Add(Cache{Task: t}, fileIDStr, fileSize, t.File)
+	var ist string
+	if t.Torrent.Name != "" {
+		ist = "☢️ torrent: "
 	}
 
-	stopAction = true
+	t.App.SendLogToChannel(t.Message.From, "doc",
+		ist+"doc file - "+name, fileIDStr)
 
-	return true
+	return fileIDStr, fileSize, nil
 }
 
 func chunkSlice[T any](items []T, chunkSize int) (chunks [][]T) {
@@ -184,33 +246,21 @@ type CacheLog struct {
 	FromCache bool
 }
 
-func (t *Task) SendAudio() bool {
+func (t *Task) SendAudio() error {
 	if len(t.Files) == 0 {
-		return false
+		return &TaskError{Key: "Something wrong... I will be fixing it", Detail: "no audio files"}
 	}
 
-	t.Send(tgbotapi.NewEditMessageText(t.Message.Chat.ID, t.MessageEditID, "📲 "+t.Lang("Sending audio")+"\n\n⏰ "+
+	t.Send(t.EditProgress("📲 " + t.Lang("Sending audio") + "\n\n⏰ " +
 		t.Lang("Time upload to the telegram ~ 1-7 minutes")))
 	t.App.SendLogToChannel(t.Message.From, "mess", "sending audio")
 
-	stopAction := false
-	go func(stopAction *bool) {
-		for {
-			if _, bo := t.App.ChatsWork.StopTasks.Load(t.Message.Chat.ID); bo {
-				return
-			}
+	stopAction := t.ChatAction("upload_audio")
 
-			if *stopAction == true {
-				return
-			}
-
-			_, _ = t.App.Bot.Send(tgbotapi.NewChatAction(t.Message.Chat.ID, "upload_audio"))
-
-			time.Sleep(4 * time.Second)
-		}
-	}(&stopAction)
-
-	var filesToLog []interface{}
+	var (
+		filesToLog []interface{}
+		errSend    error
+	)
 	for _, chuck := range chunkSlice(t.Files, 10) {
 		var filesNameForCache []string
 		var files []interface{}
@@ -221,31 +271,24 @@ func (t *Task) SendAudio() bool {
 			if fileID != "" {
 				log.Debug("add from cache")
 				tgFileID := tgbotapi.NewInputMediaAudio(tgbotapi.FileID(fileID))
-				tgFileID.Caption = name + "\n"
-				if i == len(chuck)-1 {
-					tgFileID.Caption += "\n" + t.DescriptionUrl + signAdvt
-				}
+				tgFileID.Caption = t.audioCaption(name, i == len(chuck)-1)
 				files = append(files, tgFileID)
 			} else {
 				log.Debug("add from file")
 				tgFilePath := tgbotapi.NewInputMediaAudio(tgbotapi.FilePath(val))
-				tgFilePath.Caption = name + "\n"
-				if i == len(chuck)-1 {
-					tgFilePath.Caption = "\n" + t.DescriptionUrl + signAdvt
-				}
+				tgFilePath.Caption = t.audioCaption(name, i == len(chuck)-1)
 				files = append(files, tgFilePath)
 				filesNameForCache = append(filesNameForCache, val)
 			}
 		}
 
-		sentAudio, err := t.App.Bot.SendMediaGroup(tgbotapi.NewMediaGroup(t.Message.Chat.ID, files))
+		group := tgbotapi.NewMediaGroup(t.Message.Chat.ID, files)
+		group.DisableNotification = !t.Settings.Notify
+		sentAudio, err := t.App.Bot.SendMediaGroup(group)
 		if err != nil {
+			// the rest of the chunks is still sent
 			log.Error(err)
-
-			t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "😞 "+t.Lang("Something wrong... I will be fixing it")))
-
-			t.App.SendLogToChannel(t.Message.From, "mess",
-				fmt.Sprintf("send audio err\n\n %s", err))
+			errSend = err
 		} else {
 			for _, st := range sentAudio {
 				var pathForSave string
@@ -273,7 +316,30 @@ func (t *Task) SendAudio() bool {
 		}
 	}
 
-	stopAction = true
+	stopAction()
+
+	if errSend != nil {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Retryable: true,
+			Detail: "audio send", Err: errSend}
+	}
+
+	return nil
+}
+
+// ChatAction repeats the chat action until stop is called or the task is cancelled
+func (t *Task) ChatAction(action string) context.CancelFunc {
+	ctx, stop := context.WithCancel(t.Ctx)
+	go func() {
+		for {
+			_, _ = t.App.Bot.Send(tgbotapi.NewChatAction(t.Message.Chat.ID, action))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(4 * time.Second):
+			}
+		}
+	}()
 
-	return true
+	return stop
 }
@@ -13,12 +13,16 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrTaskStopped = errors.New("task stopped")
+	ErrShutdown    = errors.New("bot is shutting down")
+)
+
 type Task struct {
 	App             *App
 	Message         *tgbotapi.Message
@@ -28,6 +32,7 @@ type Task struct {
 	MessageEditID   int
 	MessageTextLast string
 	UserFromDB      User
+	Settings        UserSettings
 	Translate       *Translate
 	Torrent         struct {
 		Name     string
@@ -36,13 +41,112 @@ type Task struct {
 	}
 	DescriptionUrl string
 	UrlIDForCache  string
+	Source         *Source
+	Job            *Job
+	Ticket         *Ticket
+	Workspace      string
+	Ctx            context.Context
+	cancel         context.CancelCauseFunc
+	// percent * 100 of the current step
+	progress atomic.Int64
+	// reservations - the disk budget of the downloads, released with the workspace
+	reservations []*diskReservation
+	// audio - the button under the progress, only the audio of the video is sent
+	audio atomic.Bool
+}
+
+func NewTask(a *App, message *tgbotapi.Message, userFromDB User, tr *Translate) *Task {
+	task := &Task{Message: message, App: a, UserFromDB: userFromDB, Translate: tr}
+	task.Ctx, task.cancel = context.WithCancelCause(a.Ctx)
+
+	return task
 }
 
 func (t *Task) Run(th ObjectHandler) {
-	th.Download()
-	th.Convert()
-	th.Send()
+	defer func() {
+		t.App.Scheduler.Release(t.Ticket)
+	}()
+
+	t.Job.Attempt()
+
+	steps := []struct {
+		state string
+		run   func() error
+	}{
+		{JobDownloading, th.Download},
+		{JobConverting, th.Convert},
+		{JobUploading, th.Send},
+	}
+
+	var err error
+	for {
+		for _, step := range steps {
+			t.SetState(step.state)
+			if err = step.run(); err != nil {
+				break
+			}
+		}
+		// the file is sent from the cache, the next one of the batch goes on
+		if errors.Is(err, ErrSentFromCache) {
+			err = nil
+		}
+
+		batch, ok := th.(ObjectBatch)
+		if !ok || t.Stopped() || err != nil && !batch.Skip(err) {
+			break
+		}
+		err = nil
+		if !batch.Next() {
+			break
+		}
+	}
 	th.Clean()
+
+	t.Finish(err)
+}
+
+// Finish - the final state of the job, the failure is reported to the user once
+func (t *Task) Finish(err error) {
+	if errors.Is(err, ErrSentFromCache) {
+		err = nil
+	}
+
+	switch {
+	case t.StoppedByUser():
+		t.Job.SetState(JobCancelled)
+	case t.Stopped():
+		// the bot is shutting down, the job is resumed after restart
+	case err != nil:
+		t.Fail(err)
+	default:
+		t.Job.SetState(JobDone)
+	}
+}
+
+func (t *Task) Fail(err error) {
+	te := AsTaskError(err)
+	log.Warnf("job %d failed: %s", t.Job.ID, err)
+
+	t.Job.Fail(te.Key, err.Error())
+
+	text := "😔 " + t.Lang(te.Key) + te.Hint
+	if te.Retryable {
+		text += "\n\n" + t.Lang("Please, try again later")
+	}
+	mess := tgbotapi.NewMessage(t.Message.Chat.ID, text)
+	mess.DisableWebPagePreview = true
+	t.Send(mess)
+
+	t.App.SendLogToChannel(t.Message.From, "mess", "❗️ "+err.Error())
+}
+
+// StopError - the reason why the task context is done
+func (t *Task) StopError() error {
+	if err := context.Cause(t.Ctx); err != nil {
+		return err
+	}
+
+	return context.Canceled
 }
 
 func (t *Task) Send(ct tgbotapi.Chattable) (tgbotapi.Message, bool) {
@@ -65,261 +169,160 @@ func (t *Task) Send(ct tgbotapi.Chattable) (tgbotapi.Message, bool) {
 	return mess, false
 }
 
-func (t *Task) Alloc(typeDl string) bool {
-	qn, _ := t.App.ChatsWork.m.Load(t.Message.MessageID)
-	t.App.SendLogToChannel(t.Message.From, "mess",
-		fmt.Sprintf("downloading %s - %s | his turn: %d",
-			typeDl, t.Message.Text, qn.(int)+1))
+func (t *Task) SetState(state string) {
+	t.SetProgress(0)
+	t.Job.SetState(state)
+}
 
-	msg := tgbotapi.NewMessage(t.Message.Chat.ID, "🍀 "+t.Lang("Download is starting soon")+"...")
+func (t *Task) SetProgress(percent float64) {
+	t.progress.Store(int64(percent * 100))
+}
 
-	// creating edit message
-	messStat, err := t.Send(msg)
-	if err {
-		return false
-	}
-	t.MessageEditID = messStat.MessageID
+func (t *Task) Progress() float64 {
+	return float64(t.progress.Load()) / 100
+}
 
-	for {
-		if _, bo := t.App.ChatsWork.StopTasks.Load(t.Message.Chat.ID); bo {
-			return true
-		}
+func (t *Task) Stop() {
+	t.cancel(ErrTaskStopped)
+	t.App.Scheduler.Cancel(t.Job.ID)
+}
 
-		// global queue
-		qn, _ := t.App.ChatsWork.m.Load(t.Message.MessageID)
-		if qn.(int) < config.MaxTasks {
-			break
-		}
+// Stopped - the task context is done: stopped by the user or the bot is shutting down
+func (t *Task) Stopped() bool {
+	return t.Ctx.Err() != nil
+}
 
-		ms := fmt.Sprintf(
-			"🍀 "+t.Lang("Download is starting soon")+"...\n\n🚦 "+t.Lang("Your queue")+": %d",
-			qn.(int)-config.MaxTasks+1)
+func (t *Task) StoppedByUser() bool {
+	return errors.Is(context.Cause(t.Ctx), ErrTaskStopped)
+}
 
-		if ms != t.MessageTextLast {
-			_, err := t.Send(tgbotapi.NewEditMessageText(t.Message.Chat.ID, t.MessageEditID, ms))
-			if err {
-				return false
-			}
-			t.MessageTextLast = ms
-		}
+func (t *Task) CancelKeyboard() tgbotapi.InlineKeyboardMarkup {
+	row := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("❌ "+t.Lang("Cancel"), fmt.Sprintf("stop:%d", t.Job.ID)))
+
+	// the video url may be sent as audio until the upload
+	if t.Source != nil && t.Source.Name == "video-url" && !t.AudioOnly() && t.Job.StateText() != JobUploading {
+		row = append(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"🎵 "+t.Lang("Audio only"), fmt.Sprintf("audio:%d", t.Job.ID))), row...)
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// EditProgress - edit of the progress message, the cancel button stays under it
+func (t *Task) EditProgress(text string) tgbotapi.EditMessageTextConfig {
+	return tgbotapi.NewEditMessageTextAndMarkup(t.Message.Chat.ID, t.MessageEditID, text, t.CancelKeyboard())
+}
 
-		time.Sleep(4 * time.Second)
+// UserTasksLimit - how many tasks of the user may run at the same time
+func (t *Task) UserTasksLimit() int {
+	if t.UserFromDB.Premium == 1 {
+		return config.UserTasksPremium
 	}
 
-	return true
+	return config.UserTasksFree
 }
 
-func (t *Task) AllocTorrent(typeDl string) bool {
-	qn, _ := t.App.TorrentChatsWork.m.Load(t.Message.MessageID)
+func (t *Task) Alloc() error {
+	typeDl := t.Source.Name
+
+	position, _ := t.App.Scheduler.Position(t.Ticket)
 	t.App.SendLogToChannel(t.Message.From, "mess",
-		fmt.Sprintf("downloading %s - %s | his turn torrent: %d",
-			typeDl, t.Message.Text, qn.(int)+1))
+		fmt.Sprintf("downloading %s - %s | his turn: %d",
+			typeDl, t.Message.Text, position))
 
-	msg := tgbotapi.NewMessage(t.Message.Chat.ID, "🍀 "+t.Lang("Download is starting soon")+"...")
+	stopHint := fmt.Sprintf("\n\n⛔️ "+t.Lang("Stop the task")+": /stop_%d", t.Job.ID)
+	msg := tgbotapi.NewMessage(t.Message.Chat.ID, "🍀 "+t.Lang("Download is starting soon")+"..."+stopHint)
+	msg.ReplyMarkup = t.CancelKeyboard()
 
 	// creating edit message
-	messStat, err := t.Send(msg)
-	if err {
-		return false
+	messStat, isErr := t.Send(msg)
+	if isErr {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Retryable: true,
+			Detail: "send the progress message"}
 	}
 	t.MessageEditID = messStat.MessageID
 
-	for {
-		if _, bo := t.App.ChatsWork.StopTasks.Load(t.Message.Chat.ID); bo {
-			return true
-		}
-
-		// torrent queue
-		qn, _ := t.App.TorrentChatsWork.m.Load(t.Message.MessageID)
-		if qn.(int) < config.MaxTasksTorrent {
-			break
-		}
-
-		ms := fmt.Sprintf(
-			"🍀 "+t.Lang("Download is starting soon")+"...\n\n🚦 "+t.Lang("Your queue")+": %d",
-			qn.(int)-config.MaxTasksTorrent+1)
+	allowed := t.Wait(t.Ticket, func(position int, eta time.Duration) {
+		ms := "🍀 " + t.Lang("Download is starting soon") + "...\n\n" + t.QueueText(position, eta) + stopHint
 
 		if ms != t.MessageTextLast {
-			_, err := t.Send(tgbotapi.NewEditMessageText(t.Message.Chat.ID, t.MessageEditID, ms))
-			if err {
-				return false
-			}
+			t.Send(t.EditProgress(ms))
 			t.MessageTextLast = ms
 		}
-
-		time.Sleep(4 * time.Second)
+	})
+	if !allowed {
+		return t.StopError()
 	}
 
-	return true
+	return nil
 }
 
-func (t *Task) Limit(typeDl string) bool {
-	if t.UserFromDB.Premium == 1 {
-		return false
-	}
-
-	var ld struct {
-		Quantity int `db:"quantity"`
-	}
-	err := Postgres.Get(&ld, `SELECT count(id) AS quantity FROM limits
-	                             WHERE type_object = $1 AND telegram_id = $2 AND
-	                           date_create BETWEEN now() - INTERVAL '24 hour' AND now()`, typeDl, t.Message.From.ID)
-	if err != nil && err != sql.ErrNoRows {
-		log.Error(err)
-		return false
-	}
-
-	re := false
-	if ld.Quantity >= 2 && typeDl == "torrent" {
-		re = true
-	} else if ld.Quantity >= 5 {
-		re = true
-	}
-
-	if re {
-		ms := tgbotapi.NewMessage(t.Message.Chat.ID, "😔 "+typeDl+" - "+
-			t.Lang("limit exceeded, try again in 24 hours")+"\n\n❤️ "+t.Lang("Support me and get unlimited")+
-			"\n https://boosty.to/torpurrbot",
-		)
-		ms.DisableWebPagePreview = true
-		t.Send(ms)
-
-		t.App.SendLogToChannel(t.Message.From, "mess", fmt.Sprintf("🪫 limit exceeded - "+typeDl))
-
+// Wait - waiting for a slot of the scheduler, the task is interrupted if the bot is shutting down
+func (t *Task) Wait(ticket *Ticket, onChange func(position int, eta time.Duration)) bool {
+	if t.App.Scheduler.Wait(ticket, onChange) {
 		return true
 	}
 
-	_, err = Postgres.Exec(`INSERT INTO limits (type_object, telegram_id, date_create)
-									VALUES ($1, $2, NOW())`, typeDl, t.Message.From.ID)
-	if err != nil {
-		log.Error(err)
-		return false
+	if t.App.draining.Load() {
+		t.cancel(ErrShutdown)
 	}
 
 	return false
 }
 
-func (t *Task) OpenKeyBoardWithTorrentFiles() *torrent.Torrent {
-	isMagnet := strings.Contains(t.Message.Text, "magnet:?xt=")
-
-	var (
-		torrentProcess *torrent.Torrent
-		file           tgbotapi.File
-		err            error
-	)
-
-	var isError bool
-
-	if !isMagnet {
-		file, err = t.App.Bot.GetFile(tgbotapi.FileConfig{FileID: t.Message.Document.FileID})
-		if err != nil {
-			isError = true
-			log.Error(err)
+func (t *Task) QueueText(position int, eta time.Duration) string {
+	ms := fmt.Sprintf("🚦 "+t.Lang("Your queue")+": %d", position)
+	if eta > 0 {
+		minutes := int(eta.Round(time.Minute).Minutes())
+		if minutes < 1 {
+			minutes = 1
 		}
+		ms += fmt.Sprintf("\n⏳ "+t.Lang("Approximate waiting time")+": ~ %d "+t.Lang("min"), minutes)
+	}
 
-		torrentProcess, err = t.App.TorClient.AddTorrentFromFile(config.TgPathLocal + "/" + config.BotToken +
-			"/" + file.FilePath)
-		if err != nil {
-			isError = true
-			log.Error(err)
-		}
+	return ms
+}
 
-		t.App.SendLogToChannel(t.Message.From, "doc",
-			"upload torrent file", t.Message.Document.FileID)
-	} else {
-		torrentProcess, err = t.App.TorClient.AddMagnet(t.Message.Text)
-		if err != nil {
-			isError = true
-			log.Warn(err)
-		}
+func (t *Task) Limit() error {
+	typeDl := t.Source.Class
 
-		t.App.SendLogToChannel(t.Message.From, "mess", "torrent magnet")
+	if t.UserFromDB.Premium == 1 {
+		return nil
 	}
 
-	if isError {
-		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID,
-			"😔 "+t.Lang("Bad torrent file or magnet link")))
-		t.App.SendLogToChannel(t.Message.From, "mess",
-			"Bad torrent file or magnet link")
+	// resumed job, the limit was counted on the first attempt
+	if t.Job != nil && t.Job.Attempts > 1 {
 		return nil
 	}
 
-	ctxTimeLimit, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	m, _ := t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "🕚 "+t.Lang("Getting data from torrent, please wait")))
-
-	select {
-	case <-torrentProcess.GotInfo():
-	case <-ctxTimeLimit.Done():
+	var ld struct {
+		Quantity int `db:"quantity"`
 	}
-	cancel()
-	t.App.Bot.Send(tgbotapi.NewDeleteMessage(t.Message.Chat.ID, m.MessageID))
-
-	if torrentProcess.Info() == nil {
-		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID,
-			"😔 "+t.Lang("No data in the torrent file or magnet link, no seeds to get info")))
-		t.App.SendLogToChannel(t.Message.From, "mess",
-			"error torrent - no files or time limit get info")
+	err := Postgres.Get(&ld, `SELECT count(id) AS quantity FROM limits
+	                             WHERE type_object = $1 AND telegram_id = $2 AND
+	                           date_create BETWEEN now() - INTERVAL '24 hour' AND now()`, typeDl, t.Message.From.ID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error(err)
 		return nil
 	}
 
-	if len(torrentProcess.Files()) == 1 {
-		f := torrentProcess.Files()[0]
-		t.Message.Text = f.Path() + " ~ " + strconv.FormatInt(f.Length()>>20, 10) + " MB"
-		return torrentProcess
-	}
-
-	var keyBoardButtons []tgbotapi.KeyboardButton
-	for index, val := range torrentProcess.Files() {
-		if index >= 50 {
-			continue
-		}
-
-		str := val.Path() + " ~ " + strconv.FormatInt(val.Length()>>20, 10) + " MB"
-		if len([]rune(str)) >= 120 {
-			str = str[len([]rune(str))-120:]
-		}
-
-		keyBoardButtons = append(keyBoardButtons, tgbotapi.NewKeyboardButton(str))
-
-		t.App.ChatsWork.TorrentProcesses.Store(str, torrentProcess)
+	if ld.Quantity >= t.Source.DailyLimit {
+		t.App.SendLogToChannel(t.Message.From, "mess", fmt.Sprintf("🪫 limit exceeded - "+typeDl))
 
-		// purify syncMap
-		go func(strIn string, chatID int64) {
-			time.Sleep(time.Hour)
-			tor, bo := t.App.ChatsWork.TorrentProcesses.LoadAndDelete(strIn)
-			if bo {
-				tor.(*torrent.Torrent).Drop()
-			}
-		}(str, t.Message.Chat.ID)
-	}
-	var keyboardButtonRows [][]tgbotapi.KeyboardButton
-	for _, val := range keyBoardButtons {
-		keyboardButtonRows = append(keyboardButtonRows, tgbotapi.NewKeyboardButtonRow(val))
+		return &TaskError{Key: "limit exceeded, try again in 24 hours", Detail: typeDl,
+			Hint: "\n\n❤️ " + t.Lang("Support me and get unlimited") + "\n https://boosty.to/torpurrbot"}
 	}
 
-	if keyboardButtonRows == nil {
-		return nil
+	_, err = Postgres.Exec(`INSERT INTO limits (type_object, telegram_id, date_create)
+									VALUES ($1, $2, NOW())`, typeDl, t.Message.From.ID)
+	if err != nil {
+		log.Error(err)
 	}
 
-	var numericKeyboard = tgbotapi.NewReplyKeyboard(keyboardButtonRows...)
-
-	msg := tgbotapi.NewMessage(t.Message.Chat.ID, "📍 "+t.Lang("Choose a file, max size 2 GB"))
-
-	msg.ReplyMarkup = numericKeyboard
-
-	mess, _ := t.Send(msg)
-
-	t.App.ChatsWork.ChosenMessageIDs.Store(mess.Chat.ID, mess.MessageID)
-
 	return nil
 }
 
-func (t *Task) CloseKeyBoardWithTorrentFiles() {
-	if messEditID, bo := t.App.ChatsWork.ChosenMessageIDs.Load(t.Message.Chat.ID); bo {
-		t.App.Bot.Send(tgbotapi.NewDeleteMessage(t.Message.Chat.ID, messEditID.(int)))
-	}
-}
-
 func (t *Task) Lang(str string) string {
 	return t.Translate.Lang(str)
 }
@@ -328,41 +331,8 @@ func (t *Task) RemoveMessageEdit() {
 	_, _ = t.App.Bot.Send(tgbotapi.NewDeleteMessage(t.Message.Chat.ID, t.MessageEditID))
 }
 
-func (t *Task) Cleaner() {
-	t.App.LockForRemove.Add(1)
-
-	if config.IsDev == false {
-		pathConvert := config.DirBot + "/storage"
-		dirs, _ := os.ReadDir(pathConvert)
-
-		for _, val := range dirs {
-			err := os.RemoveAll(pathConvert + "/" + val.Name())
-			if err != nil {
-				log.Error(err)
-			}
-		}
-
-		log.Info("Folders cleaning...")
-	}
-
-	if config.IsDev == false {
-		pathTorrent := config.DirBot + "/torrent-client"
-		tors, _ := os.ReadDir(pathTorrent)
-		for _, val := range tors {
-			if val.Name() == ".torrent.db" || val.Name() == ".torrent.db-shm" || val.Name() == ".torrent.db-wal" {
-				continue
-			}
-			err := os.RemoveAll(pathTorrent + "/" + val.Name())
-			if err != nil {
-				log.Error(err)
-			}
-		}
-	}
-
-	t.App.LockForRemove.Done()
-}
-
-func (t *Task) StatDlTor(fileChosen *torrent.File) (string, float64) {
+// StatDlTor - progress of the files, the speed is of the whole torrent
+func (t *Task) StatDlTor(files ...*torrent.File) (string, float64) {
 	if t.Torrent.Process.Info() == nil {
 		return "", 0
 	}
@@ -371,12 +341,16 @@ func (t *Task) StatDlTor(fileChosen *torrent.File) (string, float64) {
 	downloadSpeed := humanize.Bytes(uint64(currentProgress-t.Torrent.Progress)) + "/s"
 	t.Torrent.Progress = currentProgress
 
-	ctlInfo := fileChosen.FileInfo().Length
-	complete := humanize.Bytes(uint64(fileChosen.BytesCompleted()))
+	var ctlInfo, completed int64
+	for _, val := range files {
+		ctlInfo += val.FileInfo().Length
+		completed += val.BytesCompleted()
+	}
+	complete := humanize.Bytes(uint64(completed))
 	size := humanize.Bytes(uint64(ctlInfo))
 	var percentage float64
 	if ctlInfo != 0 {
-		percentage = float64(fileChosen.BytesCompleted()) / float64(ctlInfo) * 100
+		percentage = float64(completed) / float64(ctlInfo) * 100
 	}
 
 	stat := fmt.Sprintf(
@@ -388,26 +362,6 @@ func (t *Task) StatDlTor(fileChosen *torrent.File) (string, float64) {
 	return stat, percentage
 }
 
-func (t *Task) GetTimeSlice() ([]string, bool) {
-	regx := regexp.MustCompile(`-ss (.*?) -to (.{8})`)
-	matches := regx.FindStringSubmatch(t.Message.Text)
-
-	if len(matches) == 3 {
-		_, err := time.Parse(time.TimeOnly, matches[1])
-		if err != nil {
-			return nil, false
-		}
-		_, err = time.Parse(time.TimeOnly, matches[2])
-		if err != nil {
-			return nil, false
-		}
-
-		return []string{matches[1], matches[2]}, true
-	}
-
-	return nil, false
-}
-
 func (t *Task) PremiumAd(typeDl string) {
 	if t.UserFromDB.Premium == 0 && typeDl == "torrent" {
 		messPremium := tgbotapi.NewMessage(t.Message.Chat.ID, "‼️ "+
@@ -427,7 +381,7 @@ func (t *Task) PremiumAd(typeDl string) {
 	}
 }
 
-func (Task) DirSize(path string) (int64, error) {
+func (*Task) DirSize(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -441,7 +395,7 @@ func (Task) DirSize(path string) (int64, error) {
 	return size, err
 }
 
-func (Task) IsAllowFormatForConvert(pathWay string) bool {
+func (*Task) IsAllowFormatForConvert(pathWay string) bool {
 	for _, ext := range config.AllowVideoFormats {
 		if strings.ToLower(ext) == strings.ToLower(path.Ext(pathWay)) {
 			return true
@@ -451,7 +405,7 @@ func (Task) IsAllowFormatForConvert(pathWay string) bool {
 	return false
 }
 
-func (Task) UniqueId(prefix string) string {
+func (*Task) UniqueId(prefix string) string {
 	now := time.Now()
 	sec := now.Unix()
 	use := now.UnixNano() % 0x100000
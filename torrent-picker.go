@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/anacrolix/torrent"
+	"github.com/dustin/go-humanize"
+	tgbotapi "github.com/krol44/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const torrentPickerPage = 8
+
+// TorrentPicker - inline keyboard with the files of the torrent, the user browses folders,
+// checks files and starts one job for all of them
+type TorrentPicker struct {
+	// PickerBase - the flags of the message, +split allows files bigger 2 GB
+	PickerBase
+	Torrent *torrent.Torrent
+	// Premium - the folder may be sent in the zip archive
+	Premium bool
+
+	folder   string
+	page     int
+	selected map[int]bool
+	zip      bool
+}
+
+type pickerEntry struct {
+	Name   string
+	Folder bool
+	// Index of the file in the torrent, for folders - count of files
+	Index int
+	Size  int64
+}
+
+// OpenTorrentPicker adds the torrent and sends the file picker,
+// a torrent with one file is returned at once for the download
+func (t *Task) OpenTorrentPicker() *torrent.Torrent {
+	// the flags of the torrent file are in the caption
+	isMagnet := strings.Contains(t.Message.Text, "magnet:?xt=")
+	magnet, flags := t.Options().Url, t.pickerFlags()
+
+	var (
+		torrentProcess *torrent.Torrent
+		spec           *torrent.TorrentSpec
+		err            error
+	)
+
+	if !isMagnet {
+		file, errFile := t.App.Bot.GetFile(tgbotapi.FileConfig{FileID: t.Message.Document.FileID})
+		if errFile != nil {
+			log.Error(errFile)
+		}
+
+		spec, err = TorrentSpec("", config.TgPathLocal+"/"+config.BotToken+"/"+file.FilePath)
+		if err != nil {
+			log.Error(err)
+		}
+
+		t.App.SendLogToChannel(t.Message.From, "doc",
+			"upload torrent file", t.Message.Document.FileID)
+	} else {
+		spec, err = TorrentSpec(magnet, "")
+		if err != nil {
+			log.Warn(err)
+		}
+
+		t.App.SendLogToChannel(t.Message.From, "mess", "torrent magnet")
+	}
+
+	// the only file of the torrent known before is sent from the cache without the torrent
+	if err == nil {
+		cache := Cache{Task: t}
+		if name, ok := TorrentSingleFile(spec); ok && cache.TrySendTorrent(spec.InfoHash, 0, name) {
+			return nil
+		}
+
+		torrentProcess, _, err = t.App.TorClient.AddTorrentSpec(spec)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+
+	if err != nil {
+		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID,
+			"😔 "+t.Lang("Bad torrent file or magnet link")))
+		t.App.SendLogToChannel(t.Message.From, "mess",
+			"Bad torrent file or magnet link")
+		return nil
+	}
+
+	ctxTimeLimit, cancel := context.WithTimeout(t.Ctx, time.Second*30)
+	m, _ := t.Send(tgbotapi.NewMessage(t.Message.Chat.ID, "🕚 "+t.Lang("Getting data from torrent, please wait")))
+
+	select {
+	case <-torrentProcess.GotInfo():
+	case <-ctxTimeLimit.Done():
+	}
+	cancel()
+	t.App.Bot.Send(tgbotapi.NewDeleteMessage(t.Message.Chat.ID, m.MessageID))
+
+	if torrentProcess.Info() == nil {
+		t.Send(tgbotapi.NewMessage(t.Message.Chat.ID,
+			"😔 "+t.Lang("No data in the torrent file or magnet link, no seeds to get info")))
+		t.App.SendLogToChannel(t.Message.From, "mess",
+			"error torrent - no files or time limit get info")
+		return nil
+	}
+
+	t.App.Seeder.Acquire(torrentProcess)
+
+	if len(torrentProcess.Files()) == 1 {
+		t.Message.Text = "0" + flags
+		return torrentProcess
+	}
+
+	p := &TorrentPicker{
+		PickerBase: PickerBase{Flags: flags},
+		Torrent:    torrentProcess,
+		Premium:    t.UserFromDB.Premium == 1,
+		selected:   map[int]bool{},
+	}
+	// the torrent isn't needed if nothing is chosen
+	if !t.OpenPicker(p) {
+		t.App.Seeder.Release(torrentProcess)
+	}
+
+	return nil
+}
+
+func (p *TorrentPicker) split() bool {
+	o, _ := ParseOptions(p.Flags)
+
+	return o.Split
+}
+
+// Entries - subfolders and files of the current folder, folders first
+func (p *TorrentPicker) Entries() []pickerEntry {
+	folders := map[string]*pickerEntry{}
+	var entries []pickerEntry
+
+	for i, f := range p.Torrent.Files() {
+		rest := f.DisplayPath()
+		if p.folder != "" {
+			if !strings.HasPrefix(rest, p.folder+"/") {
+				continue
+			}
+			rest = strings.TrimPrefix(rest, p.folder+"/")
+		}
+
+		if name, _, isFolder := strings.Cut(rest, "/"); isFolder {
+			if folders[name] == nil {
+				folders[name] = &pickerEntry{Name: name, Folder: true}
+			}
+			folders[name].Index++
+			folders[name].Size += f.Length()
+			continue
+		}
+
+		entries = append(entries, pickerEntry{Name: rest, Index: i, Size: f.Length()})
+	}
+
+	var folderEntries []pickerEntry
+	for _, e := range folders {
+		folderEntries = append(folderEntries, *e)
+	}
+	sort.Slice(folderEntries, func(i, j int) bool {
+		return folderEntries[i].Name < folderEntries[j].Name
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	return append(folderEntries, entries...)
+}
+
+// Selected - indexes of the checked files in the torrent order
+func (p *TorrentPicker) Selected() []int {
+	var files []int
+	for i := range p.selected {
+		files = append(files, i)
+	}
+	sort.Ints(files)
+
+	return files
+}
+
+func (p *TorrentPicker) Render() (string, tgbotapi.InlineKeyboardMarkup) {
+	tr := p.Translate
+	files := p.Torrent.Files()
+
+	var size int64
+	for i := range p.selected {
+		size += files[i].Length()
+	}
+
+	text := "📍 " + tr.Lang("Choose files, max size of a file 2 GB") + "\n\n📁 /" + p.folder +
+		fmt.Sprintf("\n\n✅ %s: %d, %s", tr.Lang("Selected files"), len(p.selected), humanize.Bytes(uint64(size)))
+
+	entries := p.Entries()
+	pages := (len(entries) + torrentPickerPage - 1) / torrentPickerPage
+	if p.page >= pages {
+		p.page = pages - 1
+	}
+	if p.page < 0 {
+		p.page = 0
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := p.page * torrentPickerPage; i < len(entries) && i < (p.page+1)*torrentPickerPage; i++ {
+		e := entries[i]
+
+		var label, data string
+		switch {
+		case e.Folder:
+			label = fmt.Sprintf("📁 %s (%d) ~ %s", e.Name, e.Index, humanize.Bytes(uint64(e.Size)))
+			data = fmt.Sprintf("tp:%d:o:%d", p.ID, i)
+		case e.Size > telegramMaxFileSize && !p.split():
+			label = fmt.Sprintf("🚫 %s ~ %s", e.Name, humanize.Bytes(uint64(e.Size)))
+			data = fmt.Sprintf("tp:%d:f:%d", p.ID, e.Index)
+		default:
+			check := "☑️"
+			if p.selected[e.Index] {
+				check = "✅"
+			}
+			label = fmt.Sprintf("%s %s ~ %s", check, e.Name, humanize.Bytes(uint64(e.Size)))
+			data = fmt.Sprintf("tp:%d:f:%d", p.ID, e.Index)
+		}
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(pickerLabel(label), data)))
+	}
+
+	if pages > 1 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️", fmt.Sprintf("tp:%d:p:%d", p.ID, p.page-1)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d / %d", p.page+1, pages),
+				fmt.Sprintf("tp:%d:n", p.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("➡️", fmt.Sprintf("tp:%d:p:%d", p.ID, p.page+1)),
+		))
+	}
+
+	var tools []tgbotapi.InlineKeyboardButton
+	if p.folder != "" {
+		tools = append(tools, tgbotapi.NewInlineKeyboardButtonData("⬆️ ..", fmt.Sprintf("tp:%d:u", p.ID)))
+	}
+	tools = append(tools,
+		tgbotapi.NewInlineKeyboardButtonData("🎬 "+tr.Lang("All videos"), fmt.Sprintf("tp:%d:v", p.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("✖️ "+tr.Lang("Clear"), fmt.Sprintf("tp:%d:c", p.ID)))
+	rows = append(rows, tools)
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+		pickerLabel("🗜 "+tr.Lang("Folder in the zip archive")+" /"+p.folder), fmt.Sprintf("tp:%d:z", p.ID))))
+
+	var final []tgbotapi.InlineKeyboardButton
+	if len(p.selected) > 0 {
+		final = append(final, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("📥 %s (%d)", tr.Lang("Download"), len(p.selected)), fmt.Sprintf("tp:%d:g", p.ID)))
+	}
+	final = append(final, tgbotapi.NewInlineKeyboardButtonData("❌ "+tr.Lang("Cancel"),
+		fmt.Sprintf("tp:%d:x", p.ID)))
+	rows = append(rows, final)
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// Press - the button of the picker, returns the answer for the user and whether the picker is finished
+func (p *TorrentPicker) Press(op string, arg string) (string, bool) {
+	tr := p.Translate
+	num, _ := strconv.Atoi(arg)
+	files := p.Torrent.Files()
+
+	switch op {
+	case "f":
+		if num < 0 || num >= len(files) {
+			return "", false
+		}
+		if files[num].Length() > telegramMaxFileSize && !p.split() {
+			return tr.Lang("File is bigger 2 GB"), false
+		}
+		if p.selected[num] {
+			delete(p.selected, num)
+		} else {
+			p.selected[num] = true
+		}
+	case "o":
+		entries := p.Entries()
+		if num < 0 || num >= len(entries) || !entries[num].Folder {
+			return "", false
+		}
+		p.folder = path.Join(p.folder, entries[num].Name)
+		p.page = 0
+	case "u":
+		p.folder = path.Dir(p.folder)
+		if p.folder == "." {
+			p.folder = ""
+		}
+		p.page = 0
+	case "p":
+		p.page = num
+	case "v":
+		for i, f := range files {
+			if p.folder != "" && !strings.HasPrefix(f.DisplayPath(), p.folder+"/") {
+				continue
+			}
+			if (&Task{}).IsAllowFormatForConvert(f.DisplayPath()) && (f.Length() <= telegramMaxFileSize || p.split()) {
+				p.selected[i] = true
+			}
+		}
+	case "c":
+		p.selected = map[int]bool{}
+	case "g":
+		if len(p.selected) == 0 {
+			return "", false
+		}
+		return "", true
+	case "z":
+		if !p.Premium {
+			return tr.Lang("Available only for users who support us"), false
+		}
+		p.zip = true
+		return "", true
+	}
+
+	return "", false
+}
+
+// Choose - the files or the folder in the zip go to the job with the torrent
+func (p *TorrentPicker) Choose() PickerChoice {
+	tr := p.Translate
+	c := PickerChoice{Torrent: p.Torrent}
+
+	var size int64
+	if p.zip {
+		files := TorrentFolderFiles(p.Torrent.Files(), p.folder)
+		for _, f := range files {
+			size += f.Length()
+		}
+		c.Text = fmt.Sprintf("🗜 /%s: %d, %s", p.folder, len(files), humanize.Bytes(uint64(size)))
+		c.Choice = TorrentZipChoice(p.folder)
+
+		return c
+	}
+
+	files := p.Selected()
+	for _, i := range files {
+		size += p.Torrent.Files()[i].Length()
+	}
+	c.Text = fmt.Sprintf("📥 %s: %d, %s", tr.Lang("Selected files"), len(files), humanize.Bytes(uint64(size)))
+	c.Choice = JoinTorrentChoice(files)
+
+	return c
+}
+
+// Close - the torrent of the picker isn't needed
+func (p *TorrentPicker) Close(a *App) {
+	a.Seeder.Release(p.Torrent)
+}
+
+// JoinTorrentChoice - indexes of the chosen files for the job, "0,4,5"
+func JoinTorrentChoice(files []int) string {
+	var sp []string
+	for _, i := range files {
+		sp = append(sp, strconv.Itoa(i))
+	}
+
+	return strings.Join(sp, ",")
+}
+
+// TorrentZipChoice - the folder for the zip archive, "zip:" is the whole torrent
+func TorrentZipChoice(folder string) string {
+	return "zip:" + url.PathEscape(folder)
+}
+
+func ParseTorrentZip(choice string) (string, bool) {
+	choice, _, _ = strings.Cut(choice, " ")
+	folder, ok := strings.CutPrefix(choice, "zip:")
+	if !ok {
+		return "", false
+	}
+
+	folder, err := url.PathUnescape(folder)
+	if err != nil {
+		return "", false
+	}
+
+	return folder, true
+}
+
+// TorrentFolderFiles - the files of the folder with subfolders
+func TorrentFolderFiles(files []*torrent.File, folder string) []*torrent.File {
+	var chosen []*torrent.File
+	for _, f := range files {
+		if folder == "" || strings.HasPrefix(f.DisplayPath(), folder+"/") {
+			chosen = append(chosen, f)
+		}
+	}
+
+	return chosen
+}
+
+// ParseTorrentChoice - the chosen files, flags after the space are skipped.
+// Jobs of the reply keyboard era keep the label of one file
+func ParseTorrentChoice(choice string, files []*torrent.File) []*torrent.File {
+	indexes, _, _ := strings.Cut(choice, " ")
+
+	var chosen []*torrent.File
+	for _, val := range strings.Split(indexes, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(val))
+		if err != nil {
+			chosen = nil
+			break
+		}
+		if i >= 0 && i < len(files) {
+			chosen = append(chosen, files[i])
+		}
+	}
+	if chosen != nil {
+		return chosen
+	}
+
+	for _, f := range files {
+		if strings.Contains(f.Path()+" ~ "+strconv.FormatInt(f.Length()>>20, 10)+" MB", choice) {
+			return []*torrent.File{f}
+		}
+	}
+
+	return nil
+}
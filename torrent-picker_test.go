@@ -0,0 +1,17 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTorrentPickerSelected(t *testing.T) {
+	p := &TorrentPicker{selected: map[int]bool{7: true, 0: true, 12: true, 3: true}}
+
+	if got := p.Selected(); !reflect.DeepEqual(got, []int{0, 3, 7, 12}) {
+		t.Errorf("%v, want the torrent order", got)
+	}
+	if got := JoinTorrentChoice(p.Selected()); got != "0,3,7,12" {
+		t.Errorf("choice %s", got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// torrentMetaAge - the info of the torrent is kept so long after the last use
+const torrentMetaAge = 30 * 24 * time.Hour
+
+// TorrentSpec - the torrent of the file or the magnet link, the info of the torrent known before
+// is taken from the disk, the torrent isn't waiting for peers to get it
+func TorrentSpec(magnet string, filePath string) (*torrent.TorrentSpec, error) {
+	if filePath != "" {
+		mi, err := metainfo.LoadFromFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		return torrent.TorrentSpecFromMetaInfoErr(mi)
+	}
+
+	spec, err := torrent.TorrentSpecFromMagnetUri(magnet)
+	if err != nil {
+		return nil, err
+	}
+	if mi, err := metainfo.LoadFromFile(torrentMetaPath(spec.InfoHash)); err == nil {
+		spec.InfoBytes = mi.InfoBytes
+	}
+
+	return spec, nil
+}
+
+// TorrentSingleFile - the name of the only file of the torrent, ok is false if the info is unknown
+func TorrentSingleFile(spec *torrent.TorrentSpec) (string, bool) {
+	if spec.InfoBytes == nil {
+		return "", false
+	}
+
+	info, err := (&metainfo.MetaInfo{InfoBytes: spec.InfoBytes}).UnmarshalInfo()
+	if err != nil || len(info.UpvertedFiles()) != 1 {
+		return "", false
+	}
+
+	return info.Name, true
+}
+
+// TorrentFileIndex - index of the file in the torrent, -1 if the file isn't of the torrent
+func TorrentFileIndex(t *torrent.Torrent, nativePath string) int {
+	if t == nil || t.Info() == nil {
+		return -1
+	}
+
+	for i, val := range t.Files() {
+		pathway := path.Clean(config.DirBot + "/torrent-client/" + val.Path())
+		if nativePath == pathway || strings.HasPrefix(nativePath, pathway+".part") {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func torrentMetaPath(infoHash metainfo.Hash) string {
+	return config.DirBot + "/torrent-client/.meta/" + infoHash.HexString() + ".torrent"
+}
+
+// SaveTorrentMeta - the info of the torrent for the next magnet link with the same info hash
+func SaveTorrentMeta(t *torrent.Torrent) {
+	pathway := torrentMetaPath(t.InfoHash())
+	if _, err := os.Stat(pathway); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(pathway, now, now)
+		return
+	}
+
+	if err := os.MkdirAll(path.Dir(pathway), os.ModePerm); err != nil {
+		log.Error(err)
+		return
+	}
+	file, err := os.Create(pathway)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer file.Close()
+
+	mi := t.Metainfo()
+	if err := mi.Write(file); err != nil {
+		log.Error(err)
+	}
+}
+
+// TouchTorrentData - the data of the torrent is used, it goes to the end of the eviction
+func TouchTorrentData(t *torrent.Torrent) {
+	if t.Info() == nil {
+		return
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(config.DirBot+"/torrent-client/"+t.Info().Name, now, now)
+}
+
+// TrimTorrentData - the data of the torrents stays on the disk for the next jobs, the least recently
+// used is removed when the data is bigger the size of the cache, keep - the used and the seeded torrents
+func TrimTorrentData(keep map[string]bool) {
+	pathTorrent := config.DirBot + "/torrent-client"
+	entries, _ := os.ReadDir(pathTorrent)
+
+	type dataEntry struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	var (
+		data  []dataEntry
+		total int64
+	)
+	for _, val := range entries {
+		if strings.HasPrefix(val.Name(), ".torrent.db") || val.Name() == ".meta" || keep[val.Name()] {
+			continue
+		}
+
+		info, err := val.Info()
+		if err != nil {
+			continue
+		}
+		size := dirSize(pathTorrent + "/" + val.Name())
+		data = append(data, dataEntry{name: val.Name(), size: size, modTime: info.ModTime()})
+		total += size
+	}
+
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].modTime.Before(data[j].modTime)
+	})
+	for _, val := range data {
+		if total <= config.TorrentCacheSize {
+			break
+		}
+		if err := os.RemoveAll(pathTorrent + "/" + val.name); err != nil {
+			log.Error(err)
+			continue
+		}
+		total -= val.size
+	}
+
+	metas, _ := os.ReadDir(pathTorrent + "/.meta")
+	for _, val := range metas {
+		if info, err := val.Info(); err == nil && time.Since(info.ModTime()) > torrentMetaAge {
+			_ = os.Remove(pathTorrent + "/.meta/" + val.Name())
+		}
+	}
+}
+
+func dirSize(pathway string) int64 {
+	var size int64
+	_ = filepath.WalkDir(pathway, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size
+}
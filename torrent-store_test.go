@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"testing"
+)
+
+func TestTorrentSingleFile(t *testing.T) {
+	single, err := bencode.Marshal(metainfo.Info{Name: "movie.mkv", Length: 100, PieceLength: 1 << 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder, err := bencode.Marshal(metainfo.Info{Name: "Show", PieceLength: 1 << 14, Files: []metainfo.FileInfo{
+		{Path: []string{"s01e01.mkv"}, Length: 100},
+		{Path: []string{"s01e02.mkv"}, Length: 100},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, ok := TorrentSingleFile(&torrent.TorrentSpec{InfoBytes: single}); !ok || name != "movie.mkv" {
+		t.Errorf("single file - %q, ok %v", name, ok)
+	}
+	if _, ok := TorrentSingleFile(&torrent.TorrentSpec{InfoBytes: folder}); ok {
+		t.Error("the folder is a single file")
+	}
+	// the magnet link without the info known before
+	if _, ok := TorrentSingleFile(&torrent.TorrentSpec{}); ok {
+		t.Error("the unknown info is a single file")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/anacrolix/torrent"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const streamReadahead = 32 << 20
+
+// TorrentStream - local http server of the torrent files, ffmpeg converts the file while it is downloading.
+// Range requests let ffmpeg seek, the reader asks the torrent for the pieces in the order of reading
+type TorrentStream struct {
+	mu       sync.Mutex
+	files    map[string]*streamFile
+	listener net.Listener
+	seq      atomic.Int64
+}
+
+// StreamEnabled - the +stream flag, the torrent video is converted while it is downloading
+func (t *Task) StreamEnabled() bool {
+	return t.Options().Stream
+}
+
+type streamFile struct {
+	file *torrent.File
+	ctx  context.Context
+}
+
+// Open - url of the file for ffmpeg, close removes the file from the server
+func (s *TorrentStream) Open(ctx context.Context, file *torrent.File) (string, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", nil, err
+		}
+		s.listener = listener
+		s.files = map[string]*streamFile{}
+
+		go func() {
+			if err := http.Serve(listener, s); err != nil && !strings.Contains(err.Error(), "closed") {
+				log.Error(err)
+			}
+		}()
+	}
+
+	token := fmt.Sprintf("%d", s.seq.Add(1))
+	s.files[token] = &streamFile{file: file, ctx: ctx}
+
+	closeFile := func() {
+		s.mu.Lock()
+		delete(s.files, token)
+		s.mu.Unlock()
+	}
+
+	return fmt.Sprintf("http://%s/%s%s", s.listener.Addr(), token, path.Ext(file.Path())), closeFile, nil
+}
+
+func (s *TorrentStream) lookup(url string) *streamFile {
+	token := strings.TrimSuffix(path.Base(url), path.Ext(url))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.files[token]
+}
+
+func (s *TorrentStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sf := s.lookup(r.URL.Path)
+	if sf == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	reader := sf.file.NewReader()
+	defer reader.Close()
+	reader.SetResponsive()
+	reader.SetReadahead(streamReadahead)
+
+	// the request of ffmpeg is done with the task
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-sf.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	http.ServeContent(w, r, path.Base(sf.file.Path()), time.Time{},
+		&streamReader{Reader: reader, ctx: ctx})
+}
+
+// Close stops the server, ffmpeg reading the stream gets an error
+func (s *TorrentStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+}
+
+// streamReader - reading waits for the pieces, it is interrupted with the task
+type streamReader struct {
+	torrent.Reader
+	ctx context.Context
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	return r.Reader.ReadContext(r.ctx, p)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// localTorrent - the torrent of the file on the disk, the client has all pieces without peers
+func localTorrent(t *testing.T, data []byte) *torrent.File {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/video.mkv", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	info := metainfo.Info{PieceLength: 16 << 10}
+	if err := info.BuildFromFilePath(dir + "/video.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.DataDir = dir
+	cfg.NoDHT = true
+	cfg.DisableTrackers = true
+	cfg.NoUpload = true
+	cfg.SetListenAddr("127.0.0.1:0")
+	cfg.DisableIPv6 = true
+	cfg.DisableUTP = true
+	client, err := torrent.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	tor, err := client.AddTorrent(&metainfo.MetaInfo{InfoBytes: infoBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-tor.GotInfo()
+	tor.VerifyData()
+	if tor.BytesCompleted() != int64(len(data)) {
+		t.Fatalf("completed %d of %d", tor.BytesCompleted(), len(data))
+	}
+
+	return tor.Files()[0]
+}
+
+func TestTorrentStream(t *testing.T) {
+	data := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	file := localTorrent(t, data)
+
+	s := &TorrentStream{}
+	defer s.Close()
+	link, closeFile, err := s.Open(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(link, "/1.mkv") || s.lookup("/1.mkv") == nil || s.lookup("/2.mkv") != nil {
+		t.Fatalf("lookup of %s", link)
+	}
+
+	get := func(url string, rangeHeader string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		return resp.StatusCode, body
+	}
+
+	if code, body := get(link, ""); code != http.StatusOK || !bytes.Equal(body, data) {
+		t.Errorf("the whole file - %d, %d bytes", code, len(body))
+	}
+	// ffmpeg seeks to the index at the end of the file
+	if code, body := get(link, "bytes=70000-70099"); code != http.StatusPartialContent ||
+		!bytes.Equal(body, data[70000:70100]) {
+		t.Errorf("the range - %d, %d bytes", code, len(body))
+	}
+	if code, body := get(link, "bytes=-10"); code != http.StatusPartialContent || !bytes.Equal(body, data[len(data)-10:]) {
+		t.Errorf("the suffix range - %d, %d bytes", code, len(body))
+	}
+
+	closeFile()
+	if code, _ := get(link, ""); code != http.StatusNotFound {
+		t.Errorf("the closed file - %d", code)
+	}
+}
@@ -18,8 +18,32 @@ func (t *Translate) Lang(str string) string {
 		"Task stopped": {
 			"ru": "Задача остановлена",
 		},
-		"Allowed only one task": {
-			"ru": "Разрешена только одна задача",
+		"Stop the task": {
+			"ru": "Остановить задачу",
+		},
+		"You have no tasks": {
+			"ru": "У вас нет задач",
+		},
+		"Your tasks": {
+			"ru": "Ваши задачи",
+		},
+		"Cancel": {
+			"ru": "Отменить",
+		},
+		"queued": {
+			"ru": "в очереди",
+		},
+		"downloading": {
+			"ru": "скачивается",
+		},
+		"converting": {
+			"ru": "конвертируется",
+		},
+		"uploading": {
+			"ru": "отправляется",
+		},
+		"Task not found": {
+			"ru": "Задача не найдена",
 		},
 		"Just send me torrent file with the video files or files": {
 			"ru": "Просто отправь мне торрент файл с видео файлами или файлами",
@@ -42,6 +66,12 @@ func (t *Translate) Lang(str string) string {
 		"Download progress": {
 			"ru": "Прогресс скачивания",
 		},
+		"Download is finished, wait next step": {
+			"ru": "Загрузка завершена, ждите следующего шага",
+		},
+		"File url is bad": {
+			"ru": "Ссылка на файл некорректна",
+		},
 		"Torrent downloaded, wait next step": {
 			"ru": "Торрент скачен, ожидайте следующий шаг",
 		},
@@ -81,6 +111,9 @@ func (t *Translate) Lang(str string) string {
 		"Time upload to the telegram ~ 1-7 minutes": {
 			"ru": "Время загрузки в телеграм ~ 1-7 минут",
 		},
+		"Please, try again later": {
+			"ru": "Пожалуйста, попробуйте позже",
+		},
 		"Something wrong... I will be fixing it": {
 			"ru": "Что-то случилось, буду чинить",
 		},
@@ -93,8 +126,56 @@ func (t *Translate) Lang(str string) string {
 		"Available only for users who support us": {
 			"ru": "Доступно только для пользователей, которые поддерживают нас",
 		},
-		"Choose a file, max size 2 GB": {
-			"ru": "Выберите файл, максимальный размер 2 GB",
+		"Choose files, max size of a file 2 GB": {
+			"ru": "Выберите файлы, максимальный размер файла 2 GB",
+		},
+		"Files bigger 2 GB in parts, add to the link or to the caption of the torrent file": {
+			"ru": "Файлы больше 2 GB частями, добавьте к ссылке или к подписи торрент файла",
+		},
+		"part %d of %d": {
+			"ru": "часть %d из %d",
+		},
+		"Cutting the video into parts": {
+			"ru": "Разрезаю видео на части",
+		},
+		"Packing the file into parts": {
+			"ru": "Упаковываю файл в части",
+		},
+		"Convert torrent video while it is downloading": {
+			"ru": "Конвертировать видео торрента во время загрузки",
+		},
+		"preview": {
+			"ru": "превью",
+		},
+		"Only the first 5 minutes video is available": {
+			"ru": "Доступны только первые 5 минут видео",
+		},
+		"Full video is available for users who support us": {
+			"ru": "Полное видео доступно пользователям, которые поддерживают нас",
+		},
+		"Folder in the zip archive": {
+			"ru": "Папка в zip архиве",
+		},
+		"Packing the files into the zip": {
+			"ru": "Упаковываю файлы в zip",
+		},
+		"Selected files": {
+			"ru": "Выбрано файлов",
+		},
+		"files": {
+			"ru": "файлов",
+		},
+		"All videos": {
+			"ru": "Все видео",
+		},
+		"Clear": {
+			"ru": "Сбросить",
+		},
+		"Download": {
+			"ru": "Скачать",
+		},
+		"The list of files is expired, send the torrent again": {
+			"ru": "Список файлов устарел, отправьте торрент снова",
 		},
 		"File is bigger 2 GB": {
 			"ru": "Файл больше 2 GB",
@@ -130,6 +211,141 @@ func (t *Translate) Lang(str string) string {
 		"Didn't have time to download": {
 			"ru": "Не хватило времени на скачивание",
 		},
+		"The bot was restarted, your task is resumed": {
+			"ru": "Бот был перезапущен, ваша задача возобновлена",
+		},
+		"The bot is restarting, if your task doesn't finish in time, it will be resumed after the restart": {
+			"ru": "Бот перезапускается, если ваша задача не успеет завершиться, она будет возобновлена после перезапуска",
+		},
+		"The bot was restarted and your task failed, please send it again": {
+			"ru": "Бот был перезапущен и ваша задача не выполнена, пожалуйста, отправьте её снова",
+		},
+		"Approximate waiting time": {
+			"ru": "Примерное время ожидания",
+		},
+		"min": {
+			"ru": "мин",
+		},
+		"Waiting for free disk space": {
+			"ru": "Ожидание свободного места на диске",
+		},
+		"Not enough disk space, please try later": {
+			"ru": "Недостаточно места на диске, попробуйте позже",
+		},
+		"Getting the list of videos, please wait": {
+			"ru": "Получаю список видео, пожалуйста, подождите",
+		},
+		"Choose videos of the playlist": {
+			"ru": "Выберите видео из плейлиста",
+		},
+		"Selected videos": {
+			"ru": "Выбрано видео",
+		},
+		"First": {
+			"ru": "Первые",
+		},
+		"Max videos in one task: %d": {
+			"ru": "Максимум видео в одной задаче: %d",
+		},
+		"The list of videos is expired, send the link again": {
+			"ru": "Список видео устарел, отправьте ссылку снова",
+		},
+		"videos": {
+			"ru": "видео",
+		},
+		"Audio only": {
+			"ru": "Только аудио",
+		},
+		"Only the audio will be sent": {
+			"ru": "Будет отправлено только аудио",
+		},
+		"Extracting the audio": {
+			"ru": "Извлекаю аудио",
+		},
+		"Only the audio of the video": {
+			"ru": "Только аудио из видео",
+		},
+		"Settings": {
+			"ru": "Настройки",
+		},
+		"The flags of the message override the settings, for example +quality, +audio or +video": {
+			"ru": "Флаги сообщения важнее настроек, например +quality, +audio или +video",
+		},
+		"Quality": {
+			"ru": "Качество",
+		},
+		"Ask": {
+			"ru": "Спрашивать",
+		},
+		"Video": {
+			"ru": "Видео",
+		},
+		"Language": {
+			"ru": "Язык",
+		},
+		"Telegram": {
+			"ru": "Как в телеграме",
+		},
+		"Caption": {
+			"ru": "Подпись",
+		},
+		"Name and url": {
+			"ru": "название и ссылка",
+		},
+		"Name only": {
+			"ru": "только название",
+		},
+		"No caption": {
+			"ru": "без подписи",
+		},
+		"Forward protection": {
+			"ru": "Защита от пересылки",
+		},
+		"Notifications": {
+			"ru": "Уведомления",
+		},
+		"Close": {
+			"ru": "Закрыть",
+		},
+		"Saved": {
+			"ru": "Сохранено",
+		},
+		"The video if the audio is chosen in the settings": {
+			"ru": "Видео, если в настройках выбрано аудио",
+		},
+		"Quality, captions and notifications by default": {
+			"ru": "Качество, подписи и уведомления по умолчанию",
+		},
+		"Unknown flag": {
+			"ru": "Неизвестный флаг",
+		},
+		"The time of the slice is bad, examples: 90, 1:30, 01:02:03, 1h2m3s": {
+			"ru": "Неверное время отрезка, примеры: 90, 1:30, 01:02:03, 1h2m3s",
+		},
+		"The end of the slice must be after the start": {
+			"ru": "Конец отрезка должен быть после начала",
+		},
+		"The language of the subtitles is bad, example +subs=en,ru": {
+			"ru": "Неверный язык субтитров, пример +subs=en,ru",
+		},
+		"Subtitles of the video, the language of yours or the chosen ones": {
+			"ru": "Субтитры видео, на вашем языке или на выбранных",
+		},
+		"Choose the quality of the video, max size 2 GB": {
+			"ru": "Выберите качество видео, максимальный размер 2 ГБ",
+		},
+		"Auto": {
+			"ru": "Авто",
+		},
+		"Remember the quality": {
+			"ru": "Запомнить качество",
+		},
+		"The quality is remembered": {
+			"ru": "Качество запомнено",
+		},
+		"The list of formats is expired, send the link again": {
+			"ru": "Список форматов устарел, отправьте ссылку снова",
+		},
 	}
 
 	if re, ok := storage[str][t.Code]; ok {
@@ -2,29 +2,65 @@ package main
 
 import (
 	"github.com/anacrolix/torrent"
+	"sync/atomic"
 	"time"
 )
 
+// ObjectHandler - steps of the task, Task.Run stops at the first error.
+// Errors are *TaskError for the user message, ErrSentFromCache when nothing is left to do
 type ObjectHandler interface {
-	Download() bool
-	Convert() bool
-	Send() bool
+	Download() error
+	Convert() error
+	Send() error
 	Clean()
 }
 
 type ObjectVideoUrl struct {
 	Task *Task
+
+	// entries - ids of the chosen videos of the playlist, current - the one in work
+	entries []string
+	current int
+	// allocated - the slot is taken once for the whole playlist
+	allocated bool
+	// info of the current video, the tags of the audio
+	info InfoYtDlp
+}
+
+// ObjectBatch - handler of several files in one job, Task.Run repeats the steps while Next is true.
+// Skip - the failed file is reported and the batch goes on, false - the whole job is failed
+type ObjectBatch interface {
+	Next() bool
+	Skip(err error) bool
 }
 
 type ObjectTorrent struct {
 	Task           *Task
 	TorrentProcess *torrent.Torrent
+	// Files - chosen in the picker, downloaded and sent one by one
+	Files   []*torrent.File
+	current int
+	// raised - the files of the job with the priority, the other jobs of the torrent have their own
+	raised []*torrent.File
+	// zip - the files of the folder are sent in one archive, premium only
+	zip     bool
+	folder  string
+	volumes []string
+	adSent  bool
+	// stream - url of the file for ffmpeg, the convert goes with the download
+	stream      string
+	closeStream func()
+	stalled     atomic.Bool
 }
 
 type ObjectSpotify struct {
 	Task *Task
 }
 
+type ObjectDirectUrl struct {
+	Task *Task
+}
+
 type User struct {
 	TelegramID   int64     `db:"telegram_id"`
 	DateCreate   time.Time `db:"date_create"`
@@ -45,32 +81,40 @@ type CacheRow struct {
 	TgFromID       string    `db:"tg_from_id"`
 	TgFileID       string    `db:"tg_file_id"`
 	TgFileSize     int       `db:"tg_file_size"`
+	Variant        string    `db:"variant"`
 	DateCreate     time.Time `db:"date_create"`
 }
 
 type InfoYtDlp struct {
-	ID             string `json:"id"`
-	FullTitle      string `json:"fulltitle"`
-	FilesizeApprox int    `json:"filesize_approx"`
-	Filesize       int    `json:"filesize"`
-	Filename       string `json:"_filename"`
+	ID             string  `json:"id"`
+	FullTitle      string  `json:"fulltitle"`
+	FilesizeApprox int     `json:"filesize_approx"`
+	Filesize       int     `json:"filesize"`
+	Filename       string  `json:"_filename"`
+	Thumbnail      string  `json:"thumbnail"`
+	Uploader       string  `json:"uploader"`
+	Artist         string  `json:"artist"`
+	Track          string  `json:"track"`
+	Duration       float64 `json:"duration"`
 	Formats        []struct {
-		Ext              string `json:"ext" gorm:"column:ext"`
-		Vcodec           string `json:"vcodec" gorm:"column:vcodec"`
-		AudioExt         string `json:"audio_ext" gorm:"column:audio_ext"`
-		VideoExt         string `json:"video_ext" gorm:"column:video_ext"`
-		Preference       int    `json:"preference" gorm:"column:preference"`
-		Format           string `json:"format" gorm:"column:format"`
-		SourcePreference int    `json:"source_preference" gorm:"column:source_preference"`
-		Filesize         int    `json:"filesize" gorm:"column:filesize"`
-		DynamicRange     string `json:"dynamic_range" gorm:"column:dynamic_range"`
-		Resolution       string `json:"resolution" gorm:"column:resolution"`
-		Url              string `json:"url" gorm:"column:url"`
-		Protocol         string `json:"protocol" gorm:"column:protocol"`
-		FormatNote       string `json:"format_note" gorm:"column:format_note"`
-		Acodec           string `json:"acodec" gorm:"column:acodec"`
-		Width            int    `json:"width" gorm:"column:width"`
-		FormatID         string `json:"format_id" gorm:"column:format_id"`
-		Height           int    `json:"height" gorm:"column:height"`
+		Ext              string  `json:"ext" gorm:"column:ext"`
+		Vcodec           string  `json:"vcodec" gorm:"column:vcodec"`
+		AudioExt         string  `json:"audio_ext" gorm:"column:audio_ext"`
+		VideoExt         string  `json:"video_ext" gorm:"column:video_ext"`
+		Preference       int     `json:"preference" gorm:"column:preference"`
+		Format           string  `json:"format" gorm:"column:format"`
+		SourcePreference int     `json:"source_preference" gorm:"column:source_preference"`
+		Filesize         int     `json:"filesize" gorm:"column:filesize"`
+		FilesizeApprox   int     `json:"filesize_approx" gorm:"column:filesize_approx"`
+		Tbr              float64 `json:"tbr" gorm:"column:tbr"`
+		DynamicRange     string  `json:"dynamic_range" gorm:"column:dynamic_range"`
+		Resolution       string  `json:"resolution" gorm:"column:resolution"`
+		Url              string  `json:"url" gorm:"column:url"`
+		Protocol         string  `json:"protocol" gorm:"column:protocol"`
+		FormatNote       string  `json:"format_note" gorm:"column:format_note"`
+		Acodec           string  `json:"acodec" gorm:"column:acodec"`
+		Width            int     `json:"width" gorm:"column:width"`
+		FormatID         string  `json:"format_id" gorm:"column:format_id"`
+		Height           int     `json:"height" gorm:"column:height"`
 	} `json:"formats" gorm:"column:formats"`
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+const (
+	// workspaceMaxAge - a workspace without a task is removed by the janitor after this time
+	workspaceMaxAge = 6 * time.Hour
+	janitorInterval = 10 * time.Minute
+)
+
+// OpenWorkspace - the folder of the task in the storage, all files of the task are there,
+// it is removed only by the cleanup of the task
+func (t *Task) OpenWorkspace() error {
+	// the name is unique also for the tasks started at the same time, the janitor sweeps the storage
+	workspace, err := os.MkdirTemp(config.DirBot+"/storage", "task-*")
+	if err != nil {
+		return &TaskError{Key: "Something wrong... I will be fixing it", Retryable: true, Detail: "workspace",
+			Err: err}
+	}
+	// the telegram api server reads the files of the task, MkdirTemp makes the folder private
+	if err := os.Chmod(workspace, os.ModePerm); err != nil {
+		log.Warn(err)
+	}
+
+	t.Workspace = workspace
+	t.App.Workspaces.Store(workspace, true)
+
+	return nil
+}
+
+// Cleaner removes the workspace of the task and frees its disk reservation,
+// the data of the torrents is trimmed to the size of the cache
+func (t *Task) Cleaner() {
+	if t.Workspace != "" {
+		if config.IsDev == false {
+			if err := os.RemoveAll(t.Workspace); err != nil {
+				log.Error(err)
+			}
+		}
+		t.App.Workspaces.Delete(t.Workspace)
+	}
+	t.ReleaseReserve()
+
+	if config.IsDev == false {
+		// data of the seeded torrents and of the open pickers is kept, the rest is in the cache of the torrents
+		TrimTorrentData(t.App.Seeder.Keep())
+	}
+}
+
+// EmptyWorkspace removes the files of the previous file of the batch, the workspace is kept for the next one
+func (t *Task) EmptyWorkspace() {
+	if t.Workspace == "" || config.IsDev {
+		return
+	}
+
+	entries, err := os.ReadDir(t.Workspace)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	for _, val := range entries {
+		if err := os.RemoveAll(t.Workspace + "/" + val.Name()); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// Janitor removes the workspaces left after a crash or a restart, the workspaces of the running tasks are kept
+func (a *App) Janitor(ctx context.Context) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		a.sweepWorkspaces()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) sweepWorkspaces() {
+	if config.IsDev {
+		return
+	}
+
+	pathStorage := config.DirBot + "/storage"
+	entries, _ := os.ReadDir(pathStorage)
+	for _, val := range entries {
+		workspace := pathStorage + "/" + val.Name()
+		if _, ok := a.Workspaces.Load(workspace); ok {
+			continue
+		}
+
+		info, err := val.Info()
+		if err != nil || time.Since(info.ModTime()) < workspaceMaxAge {
+			continue
+		}
+
+		if err := os.RemoveAll(workspace); err != nil {
+			log.Error(err)
+			continue
+		}
+		log.Infof("Orphaned workspace removed - %s", val.Name())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJanitor(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.IsDev = false
+	config.DirBot = t.TempDir()
+
+	storage := config.DirBot + "/storage"
+	old := time.Now().Add(-2 * workspaceMaxAge)
+	for name, modTime := range map[string]time.Time{
+		"orphaned": old,
+		"running":  old,
+		"fresh":    time.Now(),
+	} {
+		if err := os.MkdirAll(storage+"/"+name+"/files", os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(storage+"/"+name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &App{}
+	a.Workspaces.Store(storage+"/running", true)
+
+	// the canceled janitor sweeps once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.Janitor(ctx)
+
+	for name, kept := range map[string]bool{"orphaned": false, "running": true, "fresh": true} {
+		if _, err := os.Stat(storage + "/" + name); (err == nil) != kept {
+			t.Errorf("%s - kept %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+func TestEmptyWorkspace(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.IsDev = false
+
+	task := &Task{Workspace: t.TempDir()}
+	for _, folder := range []string{"/files-video-1", "/files-convert-1"} {
+		if err := os.MkdirAll(task.Workspace+folder, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(task.Workspace+folder+"/video.mp4", []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	task.EmptyWorkspace()
+
+	entries, err := os.ReadDir(task.Workspace)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("the workspace isn't empty - %v, %v", entries, err)
+	}
+}